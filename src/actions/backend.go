@@ -0,0 +1,144 @@
+package actions
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/utils"
+)
+
+// TunnelConfig is a structure holding everything a TunnelBackend needs to bring the Wireguard interface up or down.
+type TunnelConfig struct {
+	// ConfigPath is a path to the wg-quick compatible configuration file of the profile.
+	ConfigPath string
+	Device     *forestvpn_api.Device
+	// Persist asks the backend to keep the connection through reboots if it is able to.
+	Persist bool
+	// Table is the routing table of the tunnel routes.
+	Table int
+	// Default is true for the default tunnel, which routes all the traffic not marked with the firewall mark.
+	// The other tunnels only route the traffic from their own addresses.
+	Default bool
+	// MTU is the MTU of the interface, 0 to leave it to the backend.
+	MTU int
+	// Profile is the configuration of the profile, e.g. the networks to route through the tunnel or to exclude from it.
+	Profile auth.Config
+	// Endpoints are the addresses the endpoints of the peers resolved to, keyed by endpoint, if they are pinned.
+	// The backends use them instead of resolving the endpoints, which the kill switch does not let through.
+	Endpoints map[string]*net.UDPAddr
+}
+
+// TunnelStats is a structure representing the runtime state of the Wireguard peer.
+type TunnelStats struct {
+	Endpoint        string    `json:"endpoint"`
+	LatestHandshake time.Time `json:"latest_handshake"`
+	RxBytes         int64     `json:"rx_bytes"`
+	TxBytes         int64     `json:"tx_bytes"`
+	AllowedIPs      []string  `json:"allowed_ips"`
+}
+
+// TunnelBackend is an interface implemented by every way of configuring the Wireguard interface, e.g. wg-quick, UCI or netlink.
+type TunnelBackend interface {
+	Up(config TunnelConfig) error
+	Down(config TunnelConfig) error
+	// Status reports whether the interface is up.
+	Status() (bool, error)
+	Stats() (TunnelStats, error)
+}
+
+// PeerUpdater is an interface implemented by the backends able to configure the peers of a running interface again,
+// which makes the peers forget their sessions and start a new handshake.
+type PeerUpdater interface {
+	// UpdatePeers applies the peers of the device to the interface. If resolve is true, the endpoints are resolved anew,
+	// otherwise the endpoints the interface currently uses are kept.
+	UpdatePeers(config TunnelConfig, resolve bool) error
+}
+
+// RouteUpdater is an interface implemented by the backends able to apply the allowed IPs of the peers to a running interface
+// without restarting their sessions, along with the routes of the tunnel.
+type RouteUpdater interface {
+	UpdateRoutes(config TunnelConfig) error
+}
+
+// BackendFactory is a function returning a TunnelBackend managing the interface with the given name.
+type BackendFactory func(iface string) TunnelBackend
+
+var backends = map[string]BackendFactory{}
+
+// RegisterBackend makes the backend available by name, e.g. for the --backend flag.
+// It is meant to be called from the init function of the file implementing the backend.
+func RegisterBackend(name string, factory BackendFactory) {
+	if _, exists := backends[name]; exists {
+		panic(fmt.Sprintf("backend %s is already registered", name))
+	}
+	backends[name] = factory
+}
+
+// Backends returns the sorted names of the registered backends.
+func Backends() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewBackend is a factory function returning the registered backend with the given name for the interface.
+func NewBackend(name string, iface string) (TunnelBackend, error) {
+	factory, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend: %s, expected one of: %s", name, strings.Join(Backends(), ", "))
+	}
+	return factory(iface), nil
+}
+
+// DefaultBackend returns the name of the backend native to the platform.
+// The netlink backend is only the default on Linux because State.SetUp applies the DNS servers of the device for it,
+// see managesDNS, as wg-quick does for itself; a backend leaving the DNS of the host as is must not become a default.
+func DefaultBackend() string {
+	switch {
+	case utils.Os == "windows":
+		return WindowsBackend
+	case utils.IsOpenWRT():
+		return OpenWRTBackend
+	case utils.Os == "linux":
+		return NetlinkBackend
+	}
+	return WgQuickBackend
+}
+
+// resolveBackend returns the backend name given by the flag, or the one from the profile configuration, or the DefaultBackend.
+func resolveBackend(flag string, userID auth.ProfileID) (string, error) {
+	if len(flag) > 0 {
+		return flag, nil
+	}
+
+	config, err := auth.LoadConfig(userID)
+	if err != nil {
+		return "", err
+	}
+
+	if len(config.Backend) > 0 {
+		return config.Backend, nil
+	}
+
+	return DefaultBackend(), nil
+}
+
+// errNamedTunnel is returned by the backends unable to run the named tunnels along with the default one.
+func errNamedTunnel(backend string) error {
+	return fmt.Errorf("named tunnels are not supported by the %s backend", backend)
+}
+
+// peerAllowedIPs returns the allowed IPs of the peer merged with the split tunneling networks of the profile.
+//
+// See auth.Config.AllowedIPs for more information.
+func peerAllowedIPs(peer forestvpn_api.WireGuardPeer, config TunnelConfig) ([]string, error) {
+	return config.Profile.AllowedIPs(peer.GetAllowedIps())
+}
@@ -0,0 +1,100 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+	"github.com/forestvpn/cli/api"
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/utils"
+)
+
+// LocationsTTL is the time the cached locations are used for before the API is asked whether they were modified.
+const LocationsTTL = time.Hour
+
+// locationsTimeout limits the time to wait for the API, so the cached locations are soon used if it is unreachable.
+const locationsTimeout = 15 * time.Second
+
+// LocationsFetcher is an interface of the API client fetching the locations unless they were not modified, see api.ApiClientWrapper.
+type LocationsFetcher interface {
+	GetLocationsIfModified(etag string, lastModified string, timeout time.Duration) (api.LocationsResponse, error)
+}
+
+// GetLocations is a method to get the locations from the cache in auth.AppDir. Once they are older than LocationsTTL,
+// or RefreshLocations is set, they are fetched again: conditionally with the validators of the cached response,
+// unless RefreshLocations forces a full fetch. If the API is unreachable, the cached locations are used with a warning about their age.
+func (w AuthClientWrapper) GetLocations() ([]forestvpn_api.Location, error) {
+	cache, err := auth.LoadLocationsCache()
+	if err != nil {
+		w.warn(fmt.Sprintf("The cached locations are unreadable, fetching them again: %s", err))
+		cache = nil
+	}
+
+	if cache != nil && !w.RefreshLocations && time.Since(cache.FetchedAt) < LocationsTTL {
+		return cache.Locations, nil
+	}
+
+	var etag, lastModified string
+	if cache != nil && !w.RefreshLocations {
+		etag, lastModified = cache.ETag, cache.LastModified
+	}
+
+	response, err := w.fetchLocations(etag, lastModified)
+	if err != nil {
+		if cache == nil || !unreachable(response.StatusCode) {
+			return nil, err
+		}
+
+		w.warn(fmt.Sprintf("The API is unreachable, using the locations cached %s ago: %s", utils.HumanizeDuration(time.Since(cache.FetchedAt)), err))
+		return cache.Locations, nil
+	}
+
+	if response.NotModified && cache != nil {
+		cache.FetchedAt = time.Now()
+		return cache.Locations, auth.SaveLocationsCache(*cache)
+	}
+
+	fetched := auth.LocationsCache{
+		Locations:    response.Locations,
+		ETag:         response.ETag,
+		LastModified: response.LastModified,
+		FetchedAt:    time.Now(),
+	}
+	return fetched.Locations, auth.SaveLocationsCache(fetched)
+}
+
+// fetchLocations is a method fetching the locations with the Locations, or with the ApiClient once the profile is signed in.
+// Failing to sign in is reported like an unreachable API, so the cached locations are used.
+func (w AuthClientWrapper) fetchLocations(etag string, lastModified string) (api.LocationsResponse, error) {
+	if w.Locations != nil {
+		return w.Locations.GetLocationsIfModified(etag, lastModified, locationsTimeout)
+	}
+
+	client, err := w.apiClient()
+	if err != nil {
+		return api.LocationsResponse{}, err
+	}
+
+	return client.GetLocationsIfModified(etag, lastModified, locationsTimeout)
+}
+
+// unreachable reports whether the request failed before the back-end could answer it, or the back-end failed to,
+// as opposed to the back-end rejecting the request.
+func unreachable(statusCode int) bool {
+	return statusCode == 0 || statusCode >= http.StatusInternalServerError
+}
+
+// warn is a method passing the message to Warn, if set.
+func (w AuthClientWrapper) warn(message string) {
+	if w.Warn != nil {
+		w.Warn(message)
+	}
+}
+
+// PrintWarning is a function to show the warning to the user on the standard error, so it does not break the machine-readable output.
+func PrintWarning(message string) {
+	fmt.Fprintln(os.Stderr, "Warning: "+message)
+}
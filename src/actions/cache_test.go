@@ -0,0 +1,191 @@
+package actions_test
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+	"github.com/forestvpn/cli/actions"
+	"github.com/forestvpn/cli/api"
+	"github.com/forestvpn/cli/auth"
+)
+
+// fakeLocations is a LocationsFetcher answering with the response and the error, recording the validators of the requests.
+type fakeLocations struct {
+	response api.LocationsResponse
+	err      error
+	requests [][2]string
+}
+
+func (f *fakeLocations) GetLocationsIfModified(etag string, lastModified string, timeout time.Duration) (api.LocationsResponse, error) {
+	f.requests = append(f.requests, [2]string{etag, lastModified})
+	return f.response, f.err
+}
+
+var (
+	cachedLocations  = []forestvpn_api.Location{{Id: "cached", Name: "Berlin"}}
+	fetchedLocations = []forestvpn_api.Location{{Id: "fetched", Name: "Paris"}}
+)
+
+// testLocationsCache points auth.AppDir to a temporary directory holding the cache fetched at given time, unless it is zero.
+func testLocationsCache(t *testing.T, fetchedAt time.Time) {
+	appDir := auth.AppDir
+	auth.AppDir = t.TempDir() + "/"
+	t.Cleanup(func() { auth.AppDir = appDir })
+
+	if fetchedAt.IsZero() {
+		return
+	}
+
+	cache := auth.LocationsCache{Locations: cachedLocations, ETag: `"v1"`, LastModified: "Mon, 12 Oct 2026 10:00:00 GMT", FetchedAt: fetchedAt}
+	if err := auth.SaveLocationsCache(cache); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testClient returns an AuthClientWrapper fetching the locations with the fake, collecting it's warnings.
+func testClient(fake *fakeLocations, warnings *[]string) actions.AuthClientWrapper {
+	return actions.AuthClientWrapper{Locations: fake, Warn: func(message string) { *warnings = append(*warnings, message) }}
+}
+
+func TestGetLocationsFresh(t *testing.T) {
+	testLocationsCache(t, time.Now().Add(-time.Minute))
+	fake := &fakeLocations{err: errors.New("unexpected request")}
+	var warnings []string
+
+	locations, err := testClient(fake, &warnings).GetLocations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(locations, cachedLocations) || len(fake.requests) > 0 || len(warnings) > 0 {
+		t.Errorf("expected the cached locations without requests and warnings, got %v, %d request(s), %v", locations, len(fake.requests), warnings)
+	}
+}
+
+func TestGetLocationsNotModified(t *testing.T) {
+	testLocationsCache(t, time.Now().Add(-2*actions.LocationsTTL))
+	fake := &fakeLocations{response: api.LocationsResponse{NotModified: true, StatusCode: http.StatusNotModified}}
+	var warnings []string
+
+	locations, err := testClient(fake, &warnings).GetLocations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(locations, cachedLocations) {
+		t.Errorf("expected the cached locations, got %v", locations)
+	}
+
+	expected := [][2]string{{`"v1"`, "Mon, 12 Oct 2026 10:00:00 GMT"}}
+	if !reflect.DeepEqual(fake.requests, expected) {
+		t.Errorf("expected a request with the validators %v, got %v", expected, fake.requests)
+	}
+
+	cache, err := auth.LoadLocationsCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Since(cache.FetchedAt) > time.Minute {
+		t.Errorf("expected the cache to be revalidated now, got %s", cache.FetchedAt)
+	}
+}
+
+func TestGetLocationsRefresh(t *testing.T) {
+	testLocationsCache(t, time.Now().Add(-time.Minute))
+	fake := &fakeLocations{response: api.LocationsResponse{Locations: fetchedLocations, ETag: `"v2"`, StatusCode: http.StatusOK}}
+	var warnings []string
+
+	client := testClient(fake, &warnings)
+	client.RefreshLocations = true
+
+	locations, err := client.GetLocations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(locations, fetchedLocations) {
+		t.Errorf("expected the fetched locations, got %v", locations)
+	}
+
+	if expected := [][2]string{{"", ""}}; !reflect.DeepEqual(fake.requests, expected) {
+		t.Errorf("expected a request without validators, got %v", fake.requests)
+	}
+
+	cache, err := auth.LoadLocationsCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(cache.Locations, fetchedLocations) || cache.ETag != `"v2"` || len(cache.LastModified) > 0 {
+		t.Errorf("expected the fetched locations to be cached with their validators, got %+v", cache)
+	}
+}
+
+func TestGetLocationsUnreachable(t *testing.T) {
+	cases := map[string]api.LocationsResponse{
+		"network error":       {},
+		"server error":        {StatusCode: http.StatusInternalServerError},
+		"service unavailable": {StatusCode: http.StatusServiceUnavailable},
+	}
+
+	for name, response := range cases {
+		testLocationsCache(t, time.Now().Add(-2*actions.LocationsTTL))
+		fake := &fakeLocations{response: response, err: errors.New(name)}
+		var warnings []string
+
+		locations, err := testClient(fake, &warnings).GetLocations()
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(locations, cachedLocations) || len(warnings) != 1 {
+			t.Errorf("%s: expected the cached locations with a warning, got %v, %v", name, locations, warnings)
+		}
+	}
+}
+
+func TestGetLocationsRejected(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusNotFound} {
+		testLocationsCache(t, time.Now().Add(-2*actions.LocationsTTL))
+		fake := &fakeLocations{response: api.LocationsResponse{StatusCode: status}, err: errors.New(http.StatusText(status))}
+		var warnings []string
+
+		if locations, err := testClient(fake, &warnings).GetLocations(); err == nil {
+			t.Errorf("%d: expected an error, got %v", status, locations)
+		}
+	}
+
+	testLocationsCache(t, time.Time{})
+	fake := &fakeLocations{err: errors.New("network error")}
+	var warnings []string
+
+	if locations, err := testClient(fake, &warnings).GetLocations(); err == nil {
+		t.Errorf("expected an error without cached locations, got %v", locations)
+	}
+}
+
+func TestGetLocationsMalformedCache(t *testing.T) {
+	testLocationsCache(t, time.Time{})
+	if err := os.WriteFile(auth.AppDir+auth.LocationsFile, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeLocations{response: api.LocationsResponse{Locations: fetchedLocations, StatusCode: http.StatusOK}}
+	var warnings []string
+
+	locations, err := testClient(fake, &warnings).GetLocations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(locations, fetchedLocations) || len(warnings) != 1 {
+		t.Errorf("expected the fetched locations with a warning, got %v, %v", locations, warnings)
+	}
+}
@@ -0,0 +1,354 @@
+package actions
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/utils"
+)
+
+// ResolvConf is the configuration file of the system resolver.
+const ResolvConf = "/etc/resolv.conf"
+
+// resolvConfBackup is where the original ResolvConf is kept while it is replaced, so it is restored on 'fvpn state down'.
+const resolvConfBackup = ResolvConf + ".fvpn"
+
+// dnsmasqBackup is a file keeping the original upstream servers of dnsmasq on OpenWRT while they are replaced.
+var dnsmasqBackup = auth.AppDir + "dnsmasq.json"
+
+// dnsManager is an interface implemented by every way of pointing the system resolver at the DNS servers of the tunnel.
+type dnsManager interface {
+	apply(iface string, servers []string) error
+	revert(iface string) error
+}
+
+// managesDNS reports whether the DNS servers of the device have to be applied for the backend.
+// wg-quick applies them itself from the configuration file.
+func managesDNS(backend string) bool {
+	return utils.Os == "linux" && (backend == NetlinkBackend || backend == OpenWRTBackend)
+}
+
+// detectDNSManager returns the dnsManager native to the system: dnsmasq on OpenWRT, systemd-resolved if it is running,
+// resolvconf if it is installed, and replacing ResolvConf otherwise.
+// The manager that replaced the configuration is returned as long as it's backup exists, so it is the one to revert it.
+func detectDNSManager() dnsManager {
+	if _, err := os.Stat(resolvConfBackup); err == nil {
+		return resolvConfManager{}
+	}
+
+	if _, err := os.Stat(dnsmasqBackup); err == nil || utils.IsOpenWRT() {
+		return dnsmasqManager{}
+	}
+
+	if _, err := exec.LookPath("resolvectl"); err == nil {
+		if _, err := os.Stat("/run/systemd/resolve"); err == nil {
+			return resolvedManager{}
+		}
+	}
+
+	if _, err := exec.LookPath("resolvconf"); err == nil {
+		return resolvconfManager{}
+	}
+
+	return resolvConfManager{}
+}
+
+// ApplyDNS is a function that points the system resolver at the DNS servers of the interface.
+func ApplyDNS(iface string, servers []string) error {
+	if len(servers) == 0 {
+		return nil
+	}
+	return detectDNSManager().apply(iface, servers)
+}
+
+// RevertDNS is a function that restores the system resolver configuration changed by ApplyDNS.
+func RevertDNS(iface string) error {
+	return detectDNSManager().revert(iface)
+}
+
+// resolvedManager configures the DNS servers of the link with systemd-resolved and makes it the route for all the domains with the ~. routing domain.
+type resolvedManager struct{}
+
+func (resolvedManager) apply(iface string, servers []string) error {
+	if err := runCommand("resolvectl", append([]string{"dns", iface}, servers...)...); err != nil {
+		return err
+	}
+
+	if err := runCommand("resolvectl", "domain", iface, "~."); err != nil {
+		return err
+	}
+
+	return runCommand("resolvectl", "default-route", iface, "true")
+}
+
+// revert is a no-op once the link is gone, since systemd-resolved forgets it's configuration along with it.
+func (resolvedManager) revert(iface string) error {
+	if _, err := net.InterfaceByName(iface); err != nil {
+		return nil
+	}
+	return runCommand("resolvectl", "revert", iface)
+}
+
+// resolvconfManager registers the DNS servers of the interface with resolvconf as the exclusive ones, in the same manner as wg-quick does.
+type resolvconfManager struct{}
+
+func (resolvconfManager) apply(iface string, servers []string) error {
+	command := exec.Command("resolvconf", "-a", "tun."+iface, "-m", "0", "-x")
+	command.Stdin = strings.NewReader(nameservers(servers))
+	if out, err := command.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to resolvconf: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+func (resolvconfManager) revert(iface string) error {
+	_ = exec.Command("resolvconf", "-d", "tun."+iface, "-f").Run()
+	return nil
+}
+
+// resolvConfManager replaces ResolvConf with the DNS servers of the interface, keeping the original one, or it's symlink, in resolvConfBackup.
+type resolvConfManager struct{}
+
+func (resolvConfManager) apply(iface string, servers []string) error {
+	if _, err := os.Lstat(resolvConfBackup); os.IsNotExist(err) {
+		if err := os.Rename(ResolvConf, resolvConfBackup); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	content := fmt.Sprintf("# Generated by fvpn for %s, restored on 'fvpn state down'.\n%s", iface, nameservers(servers))
+	return os.WriteFile(ResolvConf, []byte(content), 0644)
+}
+
+func (resolvConfManager) revert(iface string) error {
+	if _, err := os.Lstat(resolvConfBackup); os.IsNotExist(err) {
+		return nil
+	}
+	return os.Rename(resolvConfBackup, ResolvConf)
+}
+
+// dnsmasqManager makes dnsmasq on OpenWRT forward the queries of the router and the LAN only to the DNS servers of the interface.
+// The original upstream servers are kept in dnsmasqBackup.
+type dnsmasqManager struct{}
+
+// dnsmasqUpstream is a structure representing the upstream servers options of dnsmasq.
+type dnsmasqUpstream struct {
+	NoResolv string   `json:"noresolv"`
+	Servers  []string `json:"servers"`
+}
+
+func (dnsmasqManager) apply(iface string, servers []string) error {
+	if _, err := os.Stat(dnsmasqBackup); os.IsNotExist(err) {
+		var upstream dnsmasqUpstream
+		noresolv, _ := exec.Command("uci", "-q", "get", "dhcp.@dnsmasq[0].noresolv").Output()
+		upstream.NoResolv = strings.TrimSpace(string(noresolv))
+		list, _ := exec.Command("uci", "-q", "get", "dhcp.@dnsmasq[0].server").Output()
+		upstream.Servers = strings.Fields(string(list))
+
+		data, err := json.Marshal(upstream)
+		if err != nil {
+			return err
+		}
+
+		if err := auth.JsonDump(data, dnsmasqBackup); err != nil {
+			return err
+		}
+	}
+
+	return setDnsmasqUpstream(dnsmasqUpstream{NoResolv: "1", Servers: servers})
+}
+
+func (dnsmasqManager) revert(iface string) error {
+	data, err := os.ReadFile(dnsmasqBackup)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var upstream dnsmasqUpstream
+	if err := json.Unmarshal(data, &upstream); err != nil {
+		return err
+	}
+
+	if err := setDnsmasqUpstream(upstream); err != nil {
+		return err
+	}
+
+	return os.Remove(dnsmasqBackup)
+}
+
+// setDnsmasqUpstream replaces the upstream servers options of dnsmasq with UCI and restarts it.
+func setDnsmasqUpstream(upstream dnsmasqUpstream) error {
+	_ = exec.Command("uci", "-q", "delete", "dhcp.@dnsmasq[0].server").Run()
+	_ = exec.Command("uci", "-q", "delete", "dhcp.@dnsmasq[0].noresolv").Run()
+
+	if len(upstream.NoResolv) > 0 {
+		if err := runCommand("uci", "set", "dhcp.@dnsmasq[0].noresolv="+upstream.NoResolv); err != nil {
+			return err
+		}
+	}
+
+	for _, server := range upstream.Servers {
+		if err := runCommand("uci", "add_list", "dhcp.@dnsmasq[0].server="+server); err != nil {
+			return err
+		}
+	}
+
+	if err := runCommand("uci", "commit", "dhcp"); err != nil {
+		return err
+	}
+
+	return runCommand("/etc/init.d/dnsmasq", "restart")
+}
+
+// nameservers renders the servers in the resolv.conf format.
+func nameservers(servers []string) string {
+	var content strings.Builder
+	for _, server := range servers {
+		fmt.Fprintf(&content, "nameserver %s\n", server)
+	}
+	return content.String()
+}
+
+// runCommand runs the shell command, returning an error with it's output if it fails.
+func runCommand(name string, args ...string) error {
+	if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to %s %s: %s: %w", name, strings.Join(args, " "), strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// DNSLeak is a structure describing a resolver answering the queries outside of the tunnel.
+type DNSLeak struct {
+	Server string `json:"server"`
+	// Interface is the interface the queries to the server are routed through.
+	Interface string `json:"interface"`
+}
+
+// CheckDNSLeaks is a function that looks for the resolvers the system is configured with, which answer the queries outside of the interface.
+// The resolvers are read from ResolvConf and, if it points at the stub of systemd-resolved, from the links of systemd-resolved
+// unless the interface holds the ~. routing domain. Every resolver the queries to are not routed through the interface is sent a query.
+func CheckDNSLeaks(iface string) ([]DNSLeak, error) {
+	if utils.Os != "linux" {
+		return nil, fmt.Errorf("DNS leak check is only supported on Linux")
+	}
+
+	servers, err := resolvConfServers(ResolvConf)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := detectDNSManager().(resolvedManager); ok {
+		servers = append(servers, resolvedServers(iface)...)
+	}
+
+	var leaks []DNSLeak
+	seen := make(map[string]bool)
+	for _, server := range servers {
+		ip := net.ParseIP(server)
+		if ip == nil || ip.IsLoopback() || seen[server] {
+			continue
+		}
+		seen[server] = true
+
+		stdout, err := exec.Command("ip", "route", "get", server).Output()
+		if err != nil {
+			continue
+		}
+
+		dev := routeDevice(string(stdout))
+		if dev == iface || !answersDNS(server) {
+			continue
+		}
+
+		leaks = append(leaks, DNSLeak{Server: server, Interface: dev})
+	}
+
+	return leaks, nil
+}
+
+// resolvConfServers reads the nameservers from the file in the resolv.conf format.
+func resolvConfServers(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+
+	return servers, scanner.Err()
+}
+
+// resolvedServers returns the DNS servers systemd-resolved forwards the queries not routed to the interface to.
+// None once the interface holds the ~. routing domain, which routes every query to it.
+func resolvedServers(iface string) []string {
+	domains, _ := exec.Command("resolvectl", "domain", iface).Output()
+	if strings.Contains(string(domains), "~.") {
+		return nil
+	}
+
+	stdout, err := exec.Command("resolvectl", "dns").Output()
+	if err != nil {
+		return nil
+	}
+
+	var servers []string
+	for _, line := range strings.Split(string(stdout), "\n") {
+		// e.g. "Link 2 (eth0): 192.168.1.1 fe80::1%eth0"
+		label, list, found := strings.Cut(line, ":")
+		if !found || strings.Contains(label, "("+iface+")") {
+			continue
+		}
+
+		for _, server := range strings.Fields(list) {
+			servers = append(servers, strings.Split(server, "%")[0])
+		}
+	}
+
+	return servers
+}
+
+// routeDevice reads the device from the output of 'ip route get'.
+func routeDevice(route string) string {
+	fields := strings.Fields(route)
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "dev" {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+// answersDNS reports whether the server answers a DNS query over UDP within a couple of seconds.
+func answersDNS(server string) bool {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "udp", net.JoinHostPort(server, "53"))
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := resolver.LookupHost(ctx, "forestvpn.com")
+	return err == nil
+}
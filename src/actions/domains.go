@@ -0,0 +1,181 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/goauthlib/pkg/logger"
+)
+
+// DefaultDomainRefreshInterval is how often the DomainRefresher resolves the domain rules by default.
+const DefaultDomainRefreshInterval = 5 * time.Minute
+
+// domainLookupTimeout limits the time spent resolving a single domain rule.
+const domainLookupTimeout = 5 * time.Second
+
+// ResolveDomains is a function that resolves the domain rules of the profile and saves their addresses, which are then merged into the allowed IPs.
+// A rule failing to resolve keeps the addresses it previously resolved to. The wildcard rules are skipped,
+// their addresses are added to the domain sets as the resolver answers them. Reports whether any of the addresses changed.
+func ResolveDomains(userID auth.ProfileID) (bool, error) {
+	config, err := auth.LoadConfig(userID)
+	if err != nil {
+		return false, err
+	}
+
+	resolved := make(auth.ResolvedDomains)
+	var failed []string
+	var lookupErr error
+
+	for _, rule := range append(append([]string{}, config.Domains...), config.BypassDomains...) {
+		if auth.IsWildcard(rule) {
+			continue
+		}
+
+		addresses, err := lookupDomain(rule)
+		if err != nil {
+			failed = append(failed, rule)
+			lookupErr = err
+			addresses = config.Resolved[rule]
+		}
+
+		if len(addresses) > 0 {
+			resolved[rule] = addresses
+		}
+	}
+
+	changed := !sameAddresses(config.Resolved, resolved)
+	if changed {
+		if err := auth.SaveResolvedDomains(userID, resolved); err != nil {
+			return false, err
+		}
+	}
+
+	if len(failed) > 0 {
+		return changed, fmt.Errorf("failed to resolve %s: %w", strings.Join(failed, ", "), lookupErr)
+	}
+
+	return changed, nil
+}
+
+// lookupDomain resolves the IPv4 and IPv6 addresses of the host, sorted.
+func lookupDomain(host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), domainLookupTimeout)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []string
+	for _, ip := range ips {
+		addresses = append(addresses, ip.IP.String())
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// sameAddresses reports whether both rules resolved to the same addresses.
+func sameAddresses(a auth.ResolvedDomains, b auth.ResolvedDomains) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for rule, addresses := range a {
+		other, ok := b[rule]
+		if !ok || strings.Join(addresses, ",") != strings.Join(other, ",") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// DomainRefresher is a structure that resolves the domain rules of the profile every Interval
+// and updates the allowed IPs and the routes of the running tunnel once their addresses change.
+type DomainRefresher struct {
+	State    State
+	UserID   auth.ProfileID
+	Interval time.Duration
+	// Locker, if set, is held during every refresh, e.g. to not interfere with setting the connection down.
+	Locker sync.Locker
+	Logger logger.Logger
+
+	applied auth.ResolvedDomains
+}
+
+// NewDomainRefresher is a factory function that returns a DomainRefresher of the State with the default interval logging through auth.SimpleLogger.
+func NewDomainRefresher(state State, userID auth.ProfileID) *DomainRefresher {
+	return &DomainRefresher{
+		State:    state,
+		UserID:   userID,
+		Interval: DefaultDomainRefreshInterval,
+		Logger:   auth.NewSimpleLogger(),
+	}
+}
+
+// Run is a method that refreshes the domain rules every Interval until the context is done.
+// The addresses the tunnel was brought up with are considered applied.
+func (r *DomainRefresher) Run(ctx context.Context) {
+	if config, err := auth.LoadConfig(r.UserID); err == nil {
+		r.applied = config.Resolved
+	}
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if r.Locker != nil {
+				r.Locker.Lock()
+			}
+			if ctx.Err() == nil {
+				r.refresh()
+			}
+			if r.Locker != nil {
+				r.Locker.Unlock()
+			}
+		}
+	}
+}
+
+// refresh is a method that resolves the domain rules and applies their addresses to the tunnel if they differ from the applied ones.
+// The addresses are saved by the refresher of any tunnel of the profile, so they are compared to the ones this tunnel was last updated with.
+func (r *DomainRefresher) refresh() {
+	log := r.Logger.WithField("interface", r.State.WiregaurdInterface)
+
+	if _, err := ResolveDomains(r.UserID); err != nil {
+		log.WithError(err).Warnf("%+v, keeping the previous addresses", err)
+	}
+
+	config, err := auth.LoadConfig(r.UserID)
+	if err != nil {
+		log.WithError(err).Errorf("failed to load the config: %+v", err)
+		return
+	}
+
+	if sameAddresses(r.applied, config.Resolved) {
+		return
+	}
+
+	if err := r.State.UpdateRoutes(r.UserID); err != nil {
+		log.WithError(err).Errorf("failed to update the routes: %+v", err)
+		return
+	}
+
+	if err := writeTunnelConfig(r.UserID, r.State.Tunnel); err != nil {
+		log.WithError(err).Errorf("failed to write the config: %+v", err)
+	}
+
+	r.applied = config.Resolved
+	log.Infof("domain rules resolved to new addresses, updated the routes")
+}
@@ -0,0 +1,210 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/utils"
+)
+
+// DomainSetTable is the nftables table holding the sets the addresses of the wildcard domain rules are added to by dnsmasq.
+const DomainSetTable = "fvpn_domains"
+
+// dnsmasqDropIn is the name of the dnsmasq configuration file telling it to add the addresses it answers for the wildcard rules to the sets.
+const dnsmasqDropIn = "fvpn.conf"
+
+// ErrDomainSetsUnsupported is returned by EnableDomainSets when dnsmasq is unable to fill the domain sets.
+var ErrDomainSetsUnsupported = errors.New("wildcard domain rules require nftables and dnsmasq built with nftset support answering the queries of the host, and are only supported on Linux")
+
+// dnsmasqConfDir returns the directory dnsmasq reads the configuration files from: /tmp/dnsmasq.d on OpenWRT and /etc/dnsmasq.d otherwise.
+func dnsmasqConfDir() string {
+	if utils.IsOpenWRT() {
+		return "/tmp/dnsmasq.d"
+	}
+	return "/etc/dnsmasq.d"
+}
+
+// domainSetsSupported reports whether dnsmasq is installed with nftset support and reads the files of the dnsmasqConfDir.
+func domainSetsSupported() bool {
+	if utils.Os != "linux" {
+		return false
+	}
+
+	if _, err := os.Stat(dnsmasqConfDir()); err != nil {
+		return false
+	}
+
+	out, err := exec.Command("dnsmasq", "--version").Output()
+	return err == nil && strings.Contains(string(out), " nftset") && !strings.Contains(string(out), "no-nftset")
+}
+
+// EnableDomainSets is a function that installs the domain sets of the default tunnel with given interface.
+// dnsmasq adds the addresses it answers for a wildcard rule, e.g. *.example.com, to the sets of the rule, matching the domain along with it's subdomains.
+// The traffic to the addresses of the BypassDomains is marked like the encrypted traffic, so the tunnel leaves it to the main table.
+// In the DomainsOnly mode the traffic to the addresses outside of the Routes, the Domains and their wildcards is marked the same way.
+// The replies are marked from the connection, so the reverse path filter lets them in.
+// The sets are removed if the profile has no wildcard rules.
+func EnableDomainSets(iface string, profile auth.Config) error {
+	if !profile.UsesDomainSets() {
+		return DisableDomainSets()
+	}
+
+	if !domainSetsSupported() {
+		return ErrDomainSetsUnsupported
+	}
+
+	ruleset, err := domainSetRuleset(iface, profile)
+	if err != nil {
+		return err
+	}
+
+	if err := replaceNftTable("inet "+DomainSetTable, ruleset); err != nil {
+		return fmt.Errorf("failed to install domain sets: %w", err)
+	}
+
+	if err := enableSrcValidMark(); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dnsmasqConfDir(), dnsmasqDropIn)
+	if current, err := os.ReadFile(path); err == nil && string(current) == dnsmasqNftsets(profile) {
+		return nil
+	}
+
+	if err := os.WriteFile(path, []byte(dnsmasqNftsets(profile)), 0644); err != nil {
+		return err
+	}
+
+	return restartDnsmasq()
+}
+
+// DisableDomainSets is a function that removes the domain sets and the dnsmasq configuration file filling them, if they are installed.
+// The src_valid_mark sysctl is restored unless the commands run by Exec still mark their traffic.
+func DisableDomainSets() error {
+	if utils.Os != "linux" {
+		return nil
+	}
+
+	if nftTableExists("inet " + DomainSetTable) {
+		if err := deleteNftTable("inet " + DomainSetTable); err != nil {
+			return fmt.Errorf("failed to remove domain sets: %w", err)
+		}
+
+		if !nftTableExists("inet " + ExecTable) {
+			if err := restoreSrcValidMark(); err != nil {
+				return err
+			}
+		}
+	}
+
+	err := os.Remove(filepath.Join(dnsmasqConfDir(), dnsmasqDropIn))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return restartDnsmasq()
+}
+
+// restartDnsmasq is a function that restarts dnsmasq, so it reads the configuration files again.
+func restartDnsmasq() error {
+	if utils.IsOpenWRT() {
+		return runCommand("/etc/init.d/dnsmasq", "restart")
+	}
+	return runCommand("systemctl", "restart", "dnsmasq")
+}
+
+// dnsmasqNftsets renders the nftset options of dnsmasq adding the addresses of the wildcard rules to the tunnel or the bypass sets.
+func dnsmasqNftsets(profile auth.Config) string {
+	var conf strings.Builder
+	fmt.Fprintf(&conf, "# Written by fvpn while the tunnel is up\n")
+	sets := []struct {
+		name  string
+		rules []string
+	}{{"tunnel", profile.Domains}, {"bypass", profile.BypassDomains}}
+
+	for _, set := range sets {
+		for _, rule := range auth.Wildcards(set.rules) {
+			domain := strings.TrimPrefix(rule, "*.")
+			fmt.Fprintf(&conf, "nftset=/%s/4#inet#%s#%s4,6#inet#%s#%s6\n", domain, DomainSetTable, set.name, DomainSetTable, set.name)
+		}
+	}
+	return conf.String()
+}
+
+// domainSetRuleset renders the domain sets table in the nft scripting format.
+// The tunnel sets start with the Routes and the addresses of the Domains, so the DomainsOnly mode keeps routing them through the tunnel.
+func domainSetRuleset(iface string, profile auth.Config) (string, error) {
+	elements := map[string][]string{"ip": nil, "ip6": nil}
+	for _, network := range append(append([]string{}, profile.Routes...), profile.Resolved.Addresses(profile.Domains)...) {
+		_, parsed, err := net.ParseCIDR(utils.HostPrefix(network))
+		if err != nil {
+			return "", err
+		}
+
+		family := "ip"
+		if parsed.IP.To4() == nil {
+			family = "ip6"
+		}
+		elements[family] = append(elements[family], parsed.String())
+	}
+
+	var ruleset strings.Builder
+	fmt.Fprintf(&ruleset, "table inet %s {\n", DomainSetTable)
+
+	for _, set := range []string{"tunnel", "bypass"} {
+		for _, family := range []string{"ip", "ip6"} {
+			name, kind := set+"4", "ipv4_addr"
+			if family == "ip6" {
+				name, kind = set+"6", "ipv6_addr"
+			}
+
+			fmt.Fprintf(&ruleset, "\tset %s {\n\t\ttype %s; flags interval; auto-merge;\n", name, kind)
+			if set == "tunnel" && len(elements[family]) > 0 {
+				fmt.Fprintf(&ruleset, "\t\telements = { %s }\n", strings.Join(elements[family], ", "))
+			}
+			fmt.Fprintf(&ruleset, "\t}\n")
+		}
+	}
+
+	var marks []string
+	for _, family := range []string{"ip", "ip6"} {
+		set := "4"
+		if family == "ip6" {
+			set = "6"
+		}
+
+		marks = append(marks, fmt.Sprintf("%s daddr @bypass%s", family, set))
+		if profile.DomainsOnly {
+			marks = append(marks, fmt.Sprintf("%s daddr != @tunnel%s", family, set))
+		}
+	}
+
+	// The traffic marked already, e.g. the encrypted traffic or the traffic of the commands run by Exec, keeps it's mark.
+	fmt.Fprintf(&ruleset, "\tchain output {\n\t\ttype route hook output priority mangle; policy accept;\n")
+	fmt.Fprintf(&ruleset, "\t\tmeta mark != 0 accept\n")
+	for _, mark := range marks {
+		fmt.Fprintf(&ruleset, "\t\t%s meta mark set %d ct mark set meta mark\n", mark, auth.DefaultTable)
+	}
+	fmt.Fprintf(&ruleset, "\t}\n")
+
+	// The traffic forwarded from the LAN is marked before it is routed, the traffic arriving through the tunnel is left as is.
+	fmt.Fprintf(&ruleset, "\tchain prerouting {\n\t\ttype filter hook prerouting priority mangle; policy accept;\n")
+	fmt.Fprintf(&ruleset, "\t\tct mark %d meta mark set ct mark accept\n", auth.DefaultTable)
+	fmt.Fprintf(&ruleset, "\t\tmeta mark != 0 accept\n")
+	fmt.Fprintf(&ruleset, "\t\tiifname %q accept\n", iface)
+	fmt.Fprintf(&ruleset, "\t\tfib daddr type local accept\n")
+	for _, mark := range marks {
+		fmt.Fprintf(&ruleset, "\t\t%s meta mark set %d ct mark set meta mark\n", mark, auth.DefaultTable)
+	}
+	fmt.Fprintf(&ruleset, "\t}\n}\n")
+
+	return ruleset.String(), nil
+}
@@ -0,0 +1,25 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNetlinkUnsupported is returned by the netlink backend on platforms without rtnetlink and generic netlink sockets.
+var ErrNetlinkUnsupported = errors.New("netlink backend is only supported on Linux")
+
+// TunnelError is an error returned when a step of configuring the Wireguard interface fails.
+// It keeps the underlying error, e.g. a syscall.Errno from netlink, so it could be inspected with errors.Is and errors.As.
+type TunnelError struct {
+	Op        string
+	Interface string
+	Err       error
+}
+
+func (e *TunnelError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Interface, e.Err)
+}
+
+func (e *TunnelError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,33 @@
+package actions
+
+import "errors"
+
+// ExecMode is a way of routing the traffic of the command run by Exec.
+type ExecMode int
+
+const (
+	// ExecThrough routes the traffic of the command through the tunnel, whatever the routes of the tunnel are.
+	ExecThrough ExecMode = iota
+	// ExecBypass routes the traffic of the command outside of the tunnels with the main routing table.
+	ExecBypass
+	// ExecIsolated runs the command in a network namespace with the Wireguard interface of the device as the only connectivity.
+	ExecIsolated
+)
+
+// ExecCgroup is the cgroup the commands run by Exec are placed under, relative to the root of the cgroup v2 hierarchy.
+const ExecCgroup = "fvpn"
+
+// ExecTable is the nftables table marking the traffic of the commands run by Exec.
+const ExecTable = "fvpn_exec"
+
+// ErrExecUnsupported is returned by Exec on the platforms without cgroup v2 and nftables.
+var ErrExecUnsupported = errors.New("exec requires cgroup v2 and nftables and is only supported on Linux")
+
+// ErrIsolatedUnsupported is returned by Exec on the platforms without network namespaces.
+var ErrIsolatedUnsupported = errors.New("isolated exec requires network namespaces and is only supported on Linux")
+
+// throughMark returns the firewall mark of the traffic routed through the table of the tunnel.
+// It differs from the table, which is the firewall mark of the encrypted traffic.
+func throughMark(table int) int {
+	return table + 0x10000
+}
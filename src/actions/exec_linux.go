@@ -0,0 +1,330 @@
+//go:build linux
+
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/forestvpn/cli/auth"
+)
+
+// cgroupRoot is the mount point of the cgroup v2 hierarchy.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// execRulePriority is the priority of the rules routing the marked traffic through the table of the tunnel.
+// They precede the rules of the tunnels.
+const execRulePriority = 31000
+
+// Exec is a function that runs the command with it's traffic routed through the tunnel of the State or outside of the tunnels,
+// and waits for it to exit.
+//
+// The command is started in a cgroup of it's mode, e.g. fvpn/bypass or fvpn/through-51821,
+// and an nftables table marks the traffic of the cgroups with a firewall mark.
+// The bypassed traffic is marked like the encrypted traffic, so the tunnels leave it to the main table.
+// The traffic routed through the tunnel is marked with it's own mark, which ip rules route through the table of the tunnel,
+// or reject once the tunnel is down. The marked traffic leaving through another interface than it was first routed to is masqueraded.
+//
+// In the ExecIsolated mode, the command is run in a network namespace of it's own with a Wireguard interface of the device of the tunnel instead.
+// The tunnel must be down, since the peer only keeps a single endpoint per device.
+//
+// Once the command exits, the cgroups left empty are removed along with their rules.
+// The table and the src_valid_mark sysctl are restored once no cgroup is left.
+// fvpn stays in the foreground until then, passing the termination signals on to the command.
+//
+// If fvpn is run with sudo, the command is run as the invoking user.
+func Exec(userID auth.ProfileID, state State, mode ExecMode, args []string) error {
+	if len(args) == 0 {
+		return errors.New("no command given")
+	}
+
+	credential, err := sudoCredential()
+	if err != nil {
+		return err
+	}
+
+	if mode == ExecIsolated {
+		if state.GetStatus() {
+			return fmt.Errorf("%s uses the device, set it down with 'fvpn state down' first", state.WiregaurdInterface)
+		}
+
+		device, err := auth.LoadTunnelDevice(userID, state.Tunnel)
+		if err != nil {
+			return err
+		}
+
+		return execIsolated(device, args, credential)
+	}
+
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return ErrExecUnsupported
+	}
+
+	group := "bypass"
+	if mode == ExecThrough {
+		if !state.GetStatus() {
+			return fmt.Errorf("%s is down, set it up with 'fvpn state up' first", state.WiregaurdInterface)
+		}
+		group = fmt.Sprintf("through-%d", state.Table)
+	}
+
+	dir := filepath.Join(cgroupRoot, ExecCgroup, group)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	err = runInCgroup(dir, mode, state.Table, args, credential)
+	return errors.Join(err, cleanUpExec())
+}
+
+// runInCgroup is a function that marks the traffic of the cgroup in the directory and runs the command in it until it exits.
+func runInCgroup(dir string, mode ExecMode, table int, args []string, credential *syscall.Credential) error {
+	if err := installExecTable(); err != nil {
+		return err
+	}
+
+	if mode == ExecThrough {
+		if err := addThroughRules(table); err != nil {
+			return err
+		}
+	}
+
+	// The replies are routed back with the mark restored from the connection, so the reverse path filter lets them in.
+	if err := enableSrcValidMark(); err != nil {
+		return err
+	}
+
+	cgroup, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer cgroup.Close()
+
+	command := exec.Command(args[0], args[1:]...)
+	command.Stdin, command.Stdout, command.Stderr = os.Stdin, os.Stdout, os.Stderr
+	command.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(cgroup.Fd()), Credential: credential}
+	if err := command.Start(); err != nil {
+		return err
+	}
+
+	// The interrupt from the terminal reaches the command by itself, the other signals are passed on to it.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if sig != os.Interrupt {
+					_ = command.Process.Signal(sig)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return command.Wait()
+}
+
+// cleanUpExec is a function that removes the cgroups under ExecCgroup which have no processes left, along with the rules routing their traffic.
+// The cgroups of the commands still running, e.g. by another Exec, are kept and the table is installed again for them.
+// Once no cgroup is left, the table is deleted and the src_valid_mark sysctl is restored unless the domain sets still mark the traffic.
+func cleanUpExec() error {
+	root := filepath.Join(cgroupRoot, ExecCgroup)
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	remaining := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		// A cgroup with processes left fails to be removed with EBUSY.
+		if err := os.Remove(filepath.Join(root, entry.Name())); errors.Is(err, syscall.EBUSY) {
+			remaining++
+			continue
+		} else if err != nil {
+			errs = append(errs, err)
+			remaining++
+			continue
+		}
+
+		if table, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), "through-")); err == nil {
+			errs = append(errs, deleteThroughRules(table))
+		}
+	}
+
+	if remaining > 0 {
+		return errors.Join(append(errs, installExecTable())...)
+	}
+
+	if err := os.Remove(root); err != nil && !os.IsNotExist(err) && !errors.Is(err, syscall.EBUSY) {
+		errs = append(errs, err)
+	}
+
+	if nftTableExists("inet " + ExecTable) {
+		if err := deleteNftTable("inet " + ExecTable); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove exec rules: %w", err))
+		}
+	}
+
+	if !nftTableExists("inet " + DomainSetTable) {
+		errs = append(errs, restoreSrcValidMark())
+	}
+
+	return errors.Join(errs...)
+}
+
+// installExecTable is a function that installs the nftables table marking the traffic of every cgroup under ExecCgroup.
+// The table is replaced, so the commands already running keep their marks.
+func installExecTable() error {
+	entries, err := os.ReadDir(filepath.Join(cgroupRoot, ExecCgroup))
+	if err != nil {
+		return err
+	}
+
+	marks := make(map[string]int)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		if entry.Name() == "bypass" {
+			marks[entry.Name()] = WireguardTable
+		} else if table, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), "through-")); err == nil {
+			marks[entry.Name()] = throughMark(table)
+		}
+	}
+
+	if err := replaceNftTable("inet "+ExecTable, execRuleset(marks)); err != nil {
+		return fmt.Errorf("failed to install exec rules: %w", err)
+	}
+
+	return nil
+}
+
+// execRuleset renders the table marking the traffic of the cgroups in the nft scripting format.
+func execRuleset(marks map[string]int) string {
+	groups := make([]string, 0, len(marks))
+	for group := range marks {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	var ruleset strings.Builder
+	fmt.Fprintf(&ruleset, "table inet %s {\n", ExecTable)
+
+	fmt.Fprintf(&ruleset, "\tchain output {\n\t\ttype route hook output priority mangle; policy accept;\n")
+	for _, group := range groups {
+		fmt.Fprintf(&ruleset, "\t\tsocket cgroupv2 level 2 \"%s/%s\" meta mark set %d ct mark set meta mark\n", ExecCgroup, group, marks[group])
+	}
+	fmt.Fprintf(&ruleset, "\t}\n")
+
+	fmt.Fprintf(&ruleset, "\tchain prerouting {\n\t\ttype filter hook prerouting priority mangle; policy accept;\n")
+	for _, group := range groups {
+		fmt.Fprintf(&ruleset, "\t\tct mark %d meta mark set ct mark\n", marks[group])
+	}
+	fmt.Fprintf(&ruleset, "\t}\n")
+
+	fmt.Fprintf(&ruleset, "\tchain postrouting {\n\t\ttype nat hook postrouting priority srcnat; policy accept;\n")
+	for _, group := range groups {
+		fmt.Fprintf(&ruleset, "\t\tsocket cgroupv2 level 2 \"%s/%s\" masquerade\n", ExecCgroup, group)
+	}
+	fmt.Fprintf(&ruleset, "\t}\n}\n")
+
+	return ruleset.String()
+}
+
+// addThroughRules is a function that adds the ip rules routing the traffic marked with the throughMark of the table through it,
+// followed by the rules rejecting it once the table is empty, so it does not leak outside of the tunnel.
+func addThroughRules(table int) error {
+	mark := strconv.Itoa(throughMark(table))
+	rules := [][]string{
+		{"fwmark", mark, "table", strconv.Itoa(table), "priority", strconv.Itoa(execRulePriority)},
+		{"fwmark", mark, "unreachable", "priority", strconv.Itoa(execRulePriority + 1)},
+	}
+
+	for _, family := range []string{"-4", "-6"} {
+		for _, rule := range rules {
+			selector := []string{family, "rule", "list", "fwmark", mark, "priority", rule[len(rule)-1]}
+			if stdout, err := exec.Command("ip", selector...).Output(); err == nil && len(stdout) > 0 {
+				continue
+			}
+
+			if out, err := exec.Command("ip", append([]string{family, "rule", "add"}, rule...)...).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to add rule: %s: %w", strings.TrimSpace(string(out)), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteThroughRules is a function that deletes the ip rules added by addThroughRules for the table, if any.
+func deleteThroughRules(table int) error {
+	mark := strconv.Itoa(throughMark(table))
+	for _, family := range []string{"-4", "-6"} {
+		for _, priority := range []int{execRulePriority, execRulePriority + 1} {
+			selector := []string{"fwmark", mark, "priority", strconv.Itoa(priority)}
+			for {
+				if stdout, err := exec.Command("ip", append([]string{family, "rule", "list"}, selector...)...).Output(); err != nil || len(stdout) == 0 {
+					break
+				}
+
+				if out, err := exec.Command("ip", append([]string{family, "rule", "del"}, selector...)...).CombinedOutput(); err != nil {
+					return fmt.Errorf("failed to delete rule: %s: %w", strings.TrimSpace(string(out)), err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// sudoCredential returns the credential of the user who invoked sudo, if any.
+func sudoCredential() (*syscall.Credential, error) {
+	uid, gid := os.Getenv("SUDO_UID"), os.Getenv("SUDO_GID")
+	if len(uid) == 0 || len(gid) == 0 || os.Geteuid() != 0 {
+		return nil, nil
+	}
+
+	parsedUID, err := strconv.ParseUint(uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedGID, err := strconv.ParseUint(gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &syscall.Credential{Uid: uint32(parsedUID), Gid: uint32(parsedGID)}
+
+	if u, err := user.LookupId(uid); err == nil {
+		groups, _ := u.GroupIds()
+		for _, group := range groups {
+			if id, err := strconv.ParseUint(group, 10, 32); err == nil {
+				credential.Groups = append(credential.Groups, uint32(id))
+			}
+		}
+	}
+
+	return credential, nil
+}
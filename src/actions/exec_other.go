@@ -0,0 +1,13 @@
+//go:build !linux
+
+package actions
+
+import "github.com/forestvpn/cli/auth"
+
+// Exec is not available outside of Linux and returns ErrExecUnsupported or ErrIsolatedUnsupported.
+func Exec(userID auth.ProfileID, state State, mode ExecMode, args []string) error {
+	if mode == ExecIsolated {
+		return ErrIsolatedUnsupported
+	}
+	return ErrExecUnsupported
+}
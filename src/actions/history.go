@@ -0,0 +1,45 @@
+package actions
+
+import (
+	"time"
+
+	"github.com/forestvpn/cli/auth"
+)
+
+// HistoryLost is the reason of the down event recorded for a connection found gone once the tunnel is set up again, e.g. after a reboot.
+const HistoryLost = "connection lost"
+
+// HistoryDisconnected is the reason of the down event recorded by SetDown.
+const HistoryDisconnected = "disconnected"
+
+// recordHistory is a method that appends the entry to the connection journal of the user along with the tunnel and the location of it's device.
+// SetUp records every connection and failure to connect, SetDown the disconnection along with it's duration and transferred bytes.
+// The journal only serves the history, failing to record the entry does not fail the connection.
+func (s *State) recordHistory(user_id auth.ProfileID, entry auth.HistoryEntry) {
+	entry.Time = time.Now()
+	entry.Interface = s.WiregaurdInterface
+	entry.Tunnel = s.Tunnel
+	entry.Backend = s.Backend
+
+	if device, err := auth.LoadTunnelDevice(user_id, s.Tunnel); err == nil {
+		location := device.GetLocation()
+		country := location.GetCountry()
+		entry.Location, entry.LocationID, entry.Country = location.GetName(), location.GetId(), country.GetName()
+	}
+
+	_ = auth.AppendHistory(user_id, entry)
+}
+
+// recordLostSession is a method that records the down event of the connection the session of the interface was left behind by,
+// e.g. once the host rebooted or the interface was removed without fvpn. The duration of such a connection is unknown.
+func (s *State) recordLostSession(user_id auth.ProfileID) {
+	sessions, err := auth.LoadSessions(user_id)
+	if err != nil {
+		return
+	}
+
+	if _, ok := sessions[s.WiregaurdInterface]; ok {
+		s.recordHistory(user_id, auth.HistoryEntry{Event: auth.HistoryDown, Reason: HistoryLost})
+		_ = auth.RemoveSession(user_id, s.WiregaurdInterface)
+	}
+}
@@ -0,0 +1,162 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/utils"
+)
+
+// The stages of the connection the hooks run at. The hooks of a stage are the executables in the <stage>.d directory of the hooks directory.
+const (
+	PreUp    = "pre-up"
+	PostUp   = "post-up"
+	PreDown  = "pre-down"
+	PostDown = "post-down"
+)
+
+// DefaultHooksDir is the directory holding the directories of the hooks unless the profile configuration says otherwise.
+var DefaultHooksDir = auth.AppDir + "hooks"
+
+// hookTimeout is how long a hook is allowed to run before it is killed.
+const hookTimeout = time.Minute
+
+// HookError is a structure representing a hook exiting with an error.
+type HookError struct {
+	Hook   string
+	Output string
+	Err    error
+}
+
+func (e *HookError) Error() string {
+	if len(e.Output) == 0 {
+		return fmt.Sprintf("hook %s failed: %s", e.Hook, e.Err)
+	}
+	return fmt.Sprintf("hook %s failed: %s: %s", e.Hook, e.Output, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// RunHooks is a function that runs the hooks of the stage of the connection of the State one after another in the lexical order of their names.
+// The hooks receive the stage, the interface, the tunnel, the location, the endpoints and the addresses of the device in FVPN_HOOK_* environment variables.
+// The hooks of the pre-up stage stop at the first failing one, so it aborts the connection. The other stages run every hook
+// and return the error of the first failing one, which does not stop SetUp or SetDown from changing the connection.
+func RunHooks(stage string, s *State, userID auth.ProfileID) error {
+	profile, err := auth.LoadConfig(userID)
+	if err != nil {
+		return err
+	}
+
+	dir := profile.HooksDir
+	if len(dir) == 0 {
+		dir = DefaultHooksDir
+	}
+
+	hooks, err := stageHooks(filepath.Join(dir, stage+".d"))
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+
+	device, err := auth.LoadTunnelDevice(userID, s.Tunnel)
+	if err != nil {
+		return err
+	}
+
+	env := append(os.Environ(), hookEnv(stage, s, device)...)
+
+	var firstErr error
+	for _, hook := range hooks {
+		err := runHook(hook, env)
+		if err == nil {
+			continue
+		}
+
+		if stage == PreUp {
+			return err
+		}
+
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// stageHooks returns the sorted paths of the executable files in the directory, none if the directory does not exist.
+func stageHooks(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var hooks []string
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		if utils.Os != "windows" && info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+
+		hooks = append(hooks, filepath.Join(dir, entry.Name()))
+	}
+
+	sort.Strings(hooks)
+	return hooks, nil
+}
+
+// hookEnv returns the environment variables describing the connection to the hooks. They are prefixed with FVPN_HOOK_,
+// so they do not collide with the environment variables of the flags of fvpn, e.g. FVPN_BACKEND, once a hook runs fvpn.
+func hookEnv(stage string, s *State, device *forestvpn_api.Device) []string {
+	location := device.GetLocation()
+	country := location.GetCountry()
+
+	var endpoints []string
+	for _, peer := range device.Wireguard.GetPeers() {
+		endpoints = append(endpoints, peer.GetEndpoint())
+	}
+
+	return []string{
+		"FVPN_HOOK_STAGE=" + stage,
+		"FVPN_HOOK_INTERFACE=" + s.WiregaurdInterface,
+		"FVPN_HOOK_TUNNEL=" + s.Tunnel,
+		"FVPN_HOOK_BACKEND=" + s.Backend,
+		"FVPN_HOOK_LOCATION=" + location.GetName(),
+		"FVPN_HOOK_LOCATION_ID=" + location.GetId(),
+		"FVPN_HOOK_COUNTRY=" + country.GetName(),
+		"FVPN_HOOK_COUNTRY_CODE=" + country.GetId(),
+		"FVPN_HOOK_ENDPOINT=" + strings.Join(endpoints, " "),
+		"FVPN_HOOK_IPS=" + strings.Join(device.GetIps(), " "),
+	}
+}
+
+// runHook runs the hook with the environment, killing it once it runs longer than hookTimeout.
+func runHook(hook string, env []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	command := exec.CommandContext(ctx, hook)
+	command.Env = env
+	command.Dir = filepath.Dir(hook)
+
+	if out, err := command.CombinedOutput(); err != nil {
+		return &HookError{Hook: hook, Output: strings.TrimSpace(string(out)), Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/utils"
+)
+
+// ErrMultiHopUnsupported is returned on the platforms unable to route one Wireguard tunnel through another.
+var ErrMultiHopUnsupported = errors.New("multi-hop connections are only supported on Linux")
+
+// SetViaLocation is a method that makes the default tunnel of the user connect through the entry location given by UUID or name.
+// The entry location is set as the location of the HopTunnel, created along with it's own device the first time,
+// while the location of the default tunnel remains the exit location. An empty location turns the multi-hop connection off.
+// It returns a SubscriptionError if the entry location requires a paid subscription.
+func (w AuthClientWrapper) SetViaLocation(userID auth.ProfileID, arg string) (*LocationWrapper, error) {
+	var location *LocationWrapper
+
+	if len(arg) > 0 {
+		if utils.Os != "linux" {
+			return nil, ErrMultiHopUnsupported
+		}
+
+		entry, err := w.SetDefaultLocation(userID, auth.HopTunnel, arg)
+		if err != nil {
+			return nil, err
+		}
+		location = &entry
+	}
+
+	config, err := auth.LoadConfig(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	config.MultiHop = location != nil
+	return location, auth.SaveConfig(userID, config)
+}
+
+// setUpHop is a method that brings the HopTunnel up, unless it is up already, and routes the endpoints of the device
+// of the default tunnel through it, so the encrypted traffic of the default tunnel reaches the exit location through the entry location.
+// It returns nil unless the profile has the multi-hop connection turned on.
+// The HopTunnel is set down again if routing the endpoints through it fails.
+func (s *State) setUpHop(userID auth.ProfileID, options UpOptions, endpoints map[string]*net.UDPAddr) (*State, error) {
+	profile, err := auth.LoadConfig(userID)
+	if err != nil || !profile.MultiHop {
+		return nil, err
+	}
+
+	if options.Persist {
+		return nil, fmt.Errorf("multi-hop connections are unable to persist through reboots")
+	}
+
+	if !auth.TunnelExists(userID, auth.HopTunnel) {
+		return nil, fmt.Errorf("no entry location, set it with 'fvpn state up --via <location>'")
+	}
+
+	hop, err := GetState(userID, auth.HopTunnel, s.Backend)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hop.GetStatus() {
+		if err := hop.SetUp(userID, UpOptions{}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := routeThroughHop(endpoints, hop.WiregaurdInterface); err != nil {
+		_ = hop.SetDown(userID)
+		return nil, err
+	}
+
+	return &hop, nil
+}
+
+// setDownHop is a method that sets the HopTunnel down if the profile has the multi-hop connection turned on.
+// The routes of the endpoints through it are gone along with it's interface.
+func (s *State) setDownHop(userID auth.ProfileID) error {
+	profile, err := auth.LoadConfig(userID)
+	if err != nil || !profile.MultiHop {
+		return err
+	}
+
+	hop, err := GetState(userID, auth.HopTunnel, s.Backend)
+	if err != nil || !hop.GetStatus() {
+		return err
+	}
+
+	return hop.SetDown(userID)
+}
+
+// reconnectHop is a method that reconnects the HopTunnel, if the profile has the multi-hop connection turned on and it is up,
+// and routes the endpoints of the device of the default tunnel through it again, as they may resolve to other addresses
+// unless the kill switch pins them.
+func (s *State) reconnectHop(userID auth.ProfileID) error {
+	profile, err := auth.LoadConfig(userID)
+	if err != nil || !profile.MultiHop {
+		return err
+	}
+
+	hop, err := GetState(userID, auth.HopTunnel, s.Backend)
+	if err != nil || !hop.GetStatus() {
+		return err
+	}
+
+	if err := hop.Reconnect(userID); err != nil {
+		return err
+	}
+
+	endpoints, err := s.endpoints(userID)
+	if err != nil {
+		return err
+	}
+
+	return routeThroughHop(endpoints, hop.WiregaurdInterface)
+}
+
+// recreateHop is a method that re-creates the HopTunnel, if the profile has the multi-hop connection turned on,
+// and routes the endpoints of the device of the default tunnel through it again, as the routes are gone along with it's interface.
+func (s *State) recreateHop(userID auth.ProfileID) error {
+	profile, err := auth.LoadConfig(userID)
+	if err != nil || !profile.MultiHop {
+		return err
+	}
+
+	hop, err := GetState(userID, auth.HopTunnel, s.Backend)
+	if err != nil {
+		return err
+	}
+
+	if err := hop.Recreate(userID, false); err != nil {
+		return err
+	}
+
+	endpoints, err := s.endpoints(userID)
+	if err != nil {
+		return err
+	}
+
+	return routeThroughHop(endpoints, hop.WiregaurdInterface)
+}
@@ -0,0 +1,120 @@
+package actions
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/utils"
+)
+
+// IPv6Table is the nftables table rejecting the IPv6 traffic outside of the tunnel.
+const IPv6Table = "fvpn_ipv6"
+
+// The ways the IPv6 traffic of the default tunnel is handled, as shown in the status.
+const (
+	// IPv6Routed is reported when the location offers the IPv6 default route.
+	IPv6Routed = "routed"
+	// IPv6Forced is reported when the IPv6 traffic is routed through the tunnel without the location offering the IPv6 default route.
+	IPv6Forced = "forced"
+	// IPv6Blocked is reported when the IPv6 traffic outside of the tunnel is rejected.
+	IPv6Blocked = "blocked"
+	// IPv6Leaking is reported when the IPv6 traffic leaves outside of the tunnel.
+	IPv6Leaking = "leaking"
+)
+
+// routesIPv6 reports whether any peer of the device offers the IPv6 default route.
+func routesIPv6(device *forestvpn_api.Device) bool {
+	for _, peer := range device.Wireguard.GetPeers() {
+		for _, cidr := range peer.GetAllowedIps() {
+			if _, network, err := net.ParseCIDR(strings.TrimSpace(cidr)); err == nil && network.IP.To4() == nil {
+				if ones, _ := network.Mask.Size(); ones == 0 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// validIPv6 checks the way of handling the IPv6 traffic given by the user.
+func validIPv6(mode string) error {
+	switch mode {
+	case "", auth.IPv6Block, auth.IPv6Tunnel, auth.IPv6Allow:
+		return nil
+	}
+	return fmt.Errorf("invalid ipv6 mode: %s, expected one of: %s, %s, %s", mode, auth.IPv6Block, auth.IPv6Tunnel, auth.IPv6Allow)
+}
+
+// ipv6Decision returns how the IPv6 traffic of the default tunnel with the device is handled.
+func ipv6Decision(device *forestvpn_api.Device, profile auth.Config) string {
+	switch {
+	case routesIPv6(device):
+		return IPv6Routed
+	case profile.IPv6 == auth.IPv6Tunnel:
+		return IPv6Forced
+	case IPv6BlockEnabled():
+		return IPv6Blocked
+	}
+	return IPv6Leaking
+}
+
+// blocksIPv6 reports whether the IPv6 traffic has to be rejected while the default tunnel is connected.
+// Blocking is opt-in with IPv6Block, as it requires nftables, and only applies on Linux to the locations offering no IPv6 default route.
+// SetUp blocks the traffic once the interface is up and SetDown unblocks it.
+func blocksIPv6(config TunnelConfig) bool {
+	return config.Default && utils.Os == "linux" && config.Profile.IPv6 == auth.IPv6Block && !routesIPv6(config.Device)
+}
+
+// BlockIPv6 is a function that installs an nftables table rejecting the IPv6 traffic sent or forwarded outside of the interface,
+// except the link-local and multicast traffic and the traffic to the resolved Wireguard endpoints.
+func BlockIPv6(iface string, endpoints []*net.UDPAddr) error {
+	accept := []string{"ip6 daddr { fe80::/10, ff00::/8 }"}
+	for _, endpoint := range endpoints {
+		if endpoint.IP.To4() == nil {
+			accept = append(accept, fmt.Sprintf("ip6 daddr %s udp dport %d", endpoint.IP, endpoint.Port))
+		}
+	}
+
+	var ruleset strings.Builder
+	fmt.Fprintf(&ruleset, "table ip6 %s {\n", IPv6Table)
+	for _, chain := range []string{"output", "forward"} {
+		fmt.Fprintf(&ruleset, "\tchain %s {\n\t\ttype filter hook %s priority 0; policy accept;\n", chain, chain)
+		fmt.Fprintf(&ruleset, "\t\toifname { \"lo\", %q } accept\n", iface)
+		for _, rule := range accept {
+			fmt.Fprintf(&ruleset, "\t\t%s accept\n", rule)
+		}
+		fmt.Fprintf(&ruleset, "\t\treject with icmpv6 type admin-prohibited\n\t}\n")
+	}
+	fmt.Fprintf(&ruleset, "}\n")
+
+	if err := replaceNftTable("ip6 "+IPv6Table, ruleset.String()); err != nil {
+		return fmt.Errorf("failed to block ipv6: %w", err)
+	}
+
+	return nil
+}
+
+// UnblockIPv6 is a function that removes the table installed by BlockIPv6, if any.
+func UnblockIPv6() error {
+	if !IPv6BlockEnabled() {
+		return nil
+	}
+
+	if err := deleteNftTable("ip6 " + IPv6Table); err != nil {
+		return fmt.Errorf("failed to unblock ipv6: %w", err)
+	}
+
+	return nil
+}
+
+// IPv6BlockEnabled is a function to check whether the table installed by BlockIPv6 is in place.
+func IPv6BlockEnabled() bool {
+	if utils.Os != "linux" {
+		return false
+	}
+
+	return nftTableExists("ip6 " + IPv6Table)
+}
@@ -0,0 +1,207 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/utils"
+)
+
+// KillSwitchTable is the nftables table holding the kill switch rules.
+const KillSwitchTable = "fvpn_killswitch"
+
+// lanNetworks are the private and link-local networks allowed by the kill switch when LAN access is requested.
+var lanNetworks = map[string][]string{
+	"ip":  {"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16", "224.0.0.0/4"},
+	"ip6": {"fc00::/7", "fe80::/10", "ff00::/8"},
+}
+
+// EnableKillSwitch is a function that installs an nftables table dropping all the traffic except
+// the traffic of the Wireguard interfaces, loopback, the Wireguard endpoints and, optionally, LAN.
+// The traffic forwarded from the LAN, e.g. on a router, is only let out through the Wireguard interfaces.
+// The network of an active SSH client is allowed as well, so the session is not dropped.
+// The endpoints are given resolved, since the kill switch drops the queries to the resolver.
+// Only the default tunnel installs it, before SetUp brings the interface up, and SetDown removes it once the interface is down.
+func EnableKillSwitch(ifaces []string, endpoints []*net.UDPAddr, allowLAN bool) error {
+	if utils.Os != "linux" {
+		return errors.New("kill switch requires nftables and is only supported on Linux")
+	}
+
+	if err := replaceNftTable("inet "+KillSwitchTable, killSwitchRuleset(ifaces, endpoints, allowLAN)); err != nil {
+		return fmt.Errorf("failed to install kill switch: %w", err)
+	}
+
+	return nil
+}
+
+// DisableKillSwitch is a function that removes the kill switch table if it is installed.
+func DisableKillSwitch() error {
+	if !KillSwitchEnabled() {
+		return nil
+	}
+
+	if err := deleteNftTable("inet " + KillSwitchTable); err != nil {
+		return fmt.Errorf("failed to remove kill switch: %w", err)
+	}
+
+	return nil
+}
+
+// KillSwitchEnabled is a function to check whether the kill switch table is installed.
+func KillSwitchEnabled() bool {
+	if utils.Os != "linux" {
+		return false
+	}
+
+	return nftTableExists("inet " + KillSwitchTable)
+}
+
+// replaceNftTable is a function that installs the ruleset defining the nftables table with given name, e.g. "inet fvpn_killswitch",
+// in place of the previously installed one. The table is replaced atomically, so no traffic passes the table in the meantime.
+// Adding the table before deleting it makes the deletion succeed when there is no table yet.
+func replaceNftTable(name string, ruleset string) error {
+	command := exec.Command("nft", "-f", "-")
+	command.Stdin = strings.NewReader(fmt.Sprintf("add table %s\ndelete table %s\n%s", name, name, ruleset))
+	if out, err := command.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w", strings.TrimSpace(string(out)), err)
+	}
+
+	return nil
+}
+
+// deleteNftTable is a function that deletes the nftables table with given name.
+func deleteNftTable(name string) error {
+	if out, err := exec.Command("nft", append([]string{"delete", "table"}, strings.Fields(name)...)...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w", strings.TrimSpace(string(out)), err)
+	}
+
+	return nil
+}
+
+// nftTableExists is a function to check whether the nftables table with given name is installed.
+func nftTableExists(name string) bool {
+	return exec.Command("nft", append([]string{"list", "table"}, strings.Fields(name)...)...).Run() == nil
+}
+
+// resolveEndpoints is a function that resolves the endpoints of the peers of the device, keyed by endpoint.
+// SetUp resolves them once before installing the kill switch, so the backends, the kill switch and the routes use the same addresses.
+func resolveEndpoints(device *forestvpn_api.Device) (map[string]*net.UDPAddr, error) {
+	endpoints := make(map[string]*net.UDPAddr)
+	for _, peer := range device.Wireguard.GetPeers() {
+		endpoint, err := net.ResolveUDPAddr("udp", peer.GetEndpoint())
+		if err != nil {
+			return nil, err
+		}
+		endpoints[peer.GetEndpoint()] = endpoint
+	}
+
+	return endpoints, nil
+}
+
+// pinnedEndpoints is a function that returns the addresses the endpoints of the connection on the interface resolved to on connecting.
+// They are only returned while the kill switch is enabled, since it lets no traffic reach other addresses, nor the resolver.
+// Otherwise the endpoints are resolved anew, e.g. once the host roamed to another network.
+func pinnedEndpoints(userID auth.ProfileID, iface string) map[string]*net.UDPAddr {
+	if !KillSwitchEnabled() {
+		return nil
+	}
+
+	return sessionEndpoints(userID, iface)
+}
+
+// sessionEndpoints is a function that returns the addresses the endpoints of the connection on the interface resolved to on connecting, if recorded.
+func sessionEndpoints(userID auth.ProfileID, iface string) map[string]*net.UDPAddr {
+	sessions, err := auth.LoadSessions(userID)
+	if err != nil {
+		return nil
+	}
+
+	endpoints := make(map[string]*net.UDPAddr)
+	for endpoint, address := range sessions[iface].Endpoints {
+		if resolved, err := net.ResolveUDPAddr("udp", address); err == nil {
+			endpoints[endpoint] = resolved
+		}
+	}
+
+	return endpoints
+}
+
+// endpointAddresses returns the addresses of the endpoints sorted, e.g. to record them in the session.
+func endpointAddresses(endpoints map[string]*net.UDPAddr) []*net.UDPAddr {
+	addresses := make([]*net.UDPAddr, 0, len(endpoints))
+	for _, address := range endpoints {
+		addresses = append(addresses, address)
+	}
+
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].String() < addresses[j].String() })
+	return addresses
+}
+
+// killSwitchRuleset renders the kill switch table in the nft scripting format.
+func killSwitchRuleset(ifaces []string, endpoints []*net.UDPAddr, allowLAN bool) string {
+	var accept []string
+
+	for _, endpoint := range endpoints {
+		family := "ip"
+		if endpoint.IP.To4() == nil {
+			family = "ip6"
+		}
+		accept = append(accept, fmt.Sprintf("%s daddr %s udp dport %d", family, endpoint.IP, endpoint.Port))
+	}
+
+	if activeSShClient := utils.GetActiveSshClient(); len(activeSShClient) > 0 {
+		accept = append(accept, fmt.Sprintf("ip daddr %s", activeSShClient))
+	}
+
+	var lan []string
+	if allowLAN {
+		for _, family := range []string{"ip", "ip6"} {
+			lan = append(lan, fmt.Sprintf("%s daddr { %s }", family, strings.Join(lanNetworks[family], ", ")))
+		}
+		accept = append(accept, lan...)
+		accept = append(accept, "udp dport { 67, 68, 546, 547 }")
+	}
+
+	var ruleset strings.Builder
+	fmt.Fprintf(&ruleset, "table inet %s {\n", KillSwitchTable)
+
+	fmt.Fprintf(&ruleset, "\tchain output {\n\t\ttype filter hook output priority 0; policy drop;\n")
+	fmt.Fprintf(&ruleset, "\t\toifname \"lo\" accept\n")
+	for _, iface := range ifaces {
+		fmt.Fprintf(&ruleset, "\t\toifname %q accept\n", iface)
+	}
+	for _, rule := range accept {
+		fmt.Fprintf(&ruleset, "\t\t%s accept\n", rule)
+	}
+	fmt.Fprintf(&ruleset, "\t}\n")
+
+	fmt.Fprintf(&ruleset, "\tchain input {\n\t\ttype filter hook input priority 0; policy drop;\n")
+	fmt.Fprintf(&ruleset, "\t\tiifname \"lo\" accept\n")
+	for _, iface := range ifaces {
+		fmt.Fprintf(&ruleset, "\t\tiifname %q accept\n", iface)
+	}
+	fmt.Fprintf(&ruleset, "\t\tct state established,related accept\n")
+	for _, rule := range accept {
+		fmt.Fprintf(&ruleset, "\t\t%s accept\n", strings.Replace(strings.Replace(rule, "daddr", "saddr", 1), "dport", "sport", 1))
+	}
+	fmt.Fprintf(&ruleset, "\t}\n")
+
+	// The LAN clients of a router only reach the Internet through the Wireguard interfaces, and the LAN if LAN access is allowed.
+	fmt.Fprintf(&ruleset, "\tchain forward {\n\t\ttype filter hook forward priority 0; policy drop;\n")
+	for _, iface := range ifaces {
+		fmt.Fprintf(&ruleset, "\t\toifname %q accept\n", iface)
+		fmt.Fprintf(&ruleset, "\t\tiifname %q accept\n", iface)
+	}
+	for _, rule := range lan {
+		fmt.Fprintf(&ruleset, "\t\t%s accept\n", rule)
+	}
+	fmt.Fprintf(&ruleset, "\t}\n}\n")
+
+	return ruleset.String()
+}
@@ -0,0 +1,350 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/utils"
+)
+
+// DefaultProbeTimeout is the time to wait for the endpoint of a location to answer a probe.
+const DefaultProbeTimeout = 2 * time.Second
+
+// LatencyTTL is the time the latency of a location is reused for before it is probed again.
+const LatencyTTL = 10 * time.Minute
+
+// EndpointTTL is the time the endpoint of a location is trusted for before it is learned again.
+const EndpointTTL = 7 * 24 * time.Hour
+
+// probeConcurrency limits the number of endpoints probed at the same time.
+const probeConcurrency = 16
+
+// pingTime matches the round-trip time in the output of ping on Linux, macOS and Windows.
+var pingTime = regexp.MustCompile(`time[=<]\s*([0-9.]+)\s*ms`)
+
+// ErrNoReachableLocation is returned when none of the probed locations answered in time.
+var ErrNoReachableLocation = errors.New("no location answered the latency probe, check your network connection")
+
+// ErrNoEndpoints is returned when the endpoint of none of the locations is known, so none of them can be probed.
+var ErrNoEndpoints = errors.New("the endpoints of the locations are unknown, set a location with 'fvpn location set' first")
+
+// LocationLatency is a structure representing the latency a location was probed with.
+// Locked locations require a paid subscription the user does not have, so they are not probed.
+type LocationLatency struct {
+	Location LocationWrapper
+	Locked   bool
+	auth.Latency
+}
+
+// ProbeLocations is a method that measures the latency to the endpoints of the locations concurrently, each within given timeout.
+// The locations API does not expose the endpoints, so they are learned from the devices the user already has, which are never changed for it.
+// The locations without a known endpoint are not probed and a warning is shown when they are the majority.
+// The latencies probed less than LatencyTTL ago are reused unless refresh is set.
+// The result is sorted by latency, with the locations not probed yet and then the unreachable ones last.
+func (w AuthClientWrapper) ProbeLocations(userID auth.ProfileID, locations []LocationWrapper, timeout time.Duration, refresh bool) ([]LocationLatency, error) {
+	cache := auth.LoadLatencyCache(userID)
+
+	w.learnEndpoints(userID, &cache, locations)
+
+	if state, err := GetState(userID, "", ""); err == nil && state.GetStatus() {
+		w.warn("The default tunnel is up, so the latencies are measured through it")
+	}
+
+	latencies := make([]LocationLatency, len(locations))
+	semaphore := make(chan struct{}, probeConcurrency)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for i, location := range locations {
+		id := location.Location.GetId()
+		latencies[i].Location = location
+
+		if latency, ok := cache.Latencies[id]; ok && !refresh && time.Since(latency.ProbedAt) < LatencyTTL {
+			latencies[i].Latency = latency
+			continue
+		}
+
+		endpoint, ok := cache.Endpoints[id]
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, id string, address string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			latency := auth.Latency{ProbedAt: time.Now()}
+			if rtt, err := pingEndpoint(address, timeout); err == nil {
+				latency.RTT = rtt
+				latency.Reachable = true
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			latencies[i].Latency = latency
+			cache.Latencies[id] = latency
+		}(i, id, endpoint.Address)
+	}
+
+	wg.Wait()
+
+	if unknown := len(locations) - len(probedLocations(latencies)); unknown*2 > len(locations) {
+		w.warn(fmt.Sprintf("The endpoints of %d of %d locations are unknown, so they are not probed. An endpoint is learned once a device of yours uses it's location, e.g. with 'fvpn location set'", unknown, len(locations)))
+	}
+
+	if err := auth.SaveLatencyCache(userID, cache); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(latencies, func(i, j int) bool {
+		if a, b := probeRank(latencies[i].Latency), probeRank(latencies[j].Latency); a != b {
+			return a < b
+		}
+		return latencies[i].RTT < latencies[j].RTT
+	})
+
+	return latencies, nil
+}
+
+// probeRank is a function ranking the latency like latencyRank: 0 if the location answered, 1 if it was not probed and 2 if it was unreachable.
+func probeRank(latency auth.Latency) int {
+	switch {
+	case latency.ProbedAt.IsZero():
+		return 1
+	case !latency.Reachable:
+		return 2
+	}
+	return 0
+}
+
+// probedLocations is a function that filters the latencies of the locations probed so far, reachable or not.
+func probedLocations(latencies []LocationLatency) []LocationLatency {
+	var probed []LocationLatency
+	for _, latency := range latencies {
+		if !latency.ProbedAt.IsZero() {
+			probed = append(probed, latency)
+		}
+	}
+	return probed
+}
+
+// learnEndpoints is a method that fills the cache with the endpoints of the locations missing a fresh one.
+// The endpoints of the devices of the default tunnel, the named tunnels and the HopTunnel are remembered first.
+// If some locations are still unknown, the devices of the account are listed to remember the endpoints they use, without changing any of them.
+// Failing to list the devices only results into a warning, as the known endpoints can still be probed.
+func (w AuthClientWrapper) learnEndpoints(userID auth.ProfileID, cache *auth.LatencyCache, locations []LocationWrapper) {
+	names := []string{"", auth.HopTunnel}
+	if config, err := auth.LoadConfig(userID); err == nil {
+		for name := range config.Tunnels {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		if !auth.TunnelExists(userID, name) {
+			continue
+		}
+
+		if device, err := auth.LoadTunnelDevice(userID, name); err == nil {
+			rememberEndpoint(cache, device)
+		}
+	}
+
+	known := true
+	for _, location := range locations {
+		if endpoint, ok := cache.Endpoints[location.Location.GetId()]; !ok || time.Since(endpoint.SeenAt) >= EndpointTTL {
+			known = false
+			break
+		}
+	}
+
+	if known {
+		return
+	}
+
+	client, err := w.apiClient()
+	if err != nil {
+		w.warn(fmt.Sprintf("Failed to list the devices to learn the endpoints of the locations: %s", err))
+		return
+	}
+
+	devices, err := client.ListDevices()
+	if err != nil {
+		w.warn(fmt.Sprintf("Failed to list the devices to learn the endpoints of the locations: %s", err))
+		return
+	}
+
+	for i := range devices {
+		rememberEndpoint(cache, &devices[i])
+	}
+}
+
+// rememberEndpoint is a function that stores the endpoint of the first peer of the device as the endpoint of it's location.
+func rememberEndpoint(cache *auth.LatencyCache, device *forestvpn_api.Device) {
+	location := device.GetLocation()
+	id := location.GetId()
+	peers := device.Wireguard.GetPeers()
+
+	if len(id) == 0 || len(peers) == 0 || len(peers[0].GetEndpoint()) == 0 {
+		return
+	}
+
+	cache.Endpoints[id] = auth.Endpoint{Address: peers[0].GetEndpoint(), SeenAt: time.Now()}
+}
+
+// recordEndpoint is a function that remembers the endpoint of the location the device of the user was just moved to.
+func recordEndpoint(userID auth.ProfileID, device *forestvpn_api.Device) error {
+	cache := auth.LoadLatencyCache(userID)
+	rememberEndpoint(&cache, device)
+	return auth.SaveLatencyCache(userID, cache)
+}
+
+// pingEndpoint is a function that sends a single ICMP echo request to the host of the endpoint and returns the round-trip time.
+// The Wireguard endpoints do not answer anything but a valid handshake on their UDP port, so the host is pinged instead.
+// While the default tunnel is up the echo request is routed through it, so the round-trip time includes the current location.
+func pingEndpoint(address string, timeout time.Duration) (time.Duration, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	switch utils.Os {
+	case "windows":
+		cmd = exec.CommandContext(ctx, "ping", "-n", "1", "-w", strconv.FormatInt(timeout.Milliseconds(), 10), host)
+	default:
+		cmd = exec.CommandContext(ctx, "ping", "-c", "1", host)
+	}
+
+	stdout, err := cmd.Output()
+	if err != nil {
+		return 0, err
+	}
+
+	match := pingTime.FindSubmatch(stdout)
+	if match == nil {
+		return 0, fmt.Errorf("no round-trip time in the output of ping: %s", stdout)
+	}
+
+	ms, err := strconv.ParseFloat(string(match[1]), 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+// SetFastestLocation is a method that probes the locations available for the user, optionally filtered by country,
+// and makes the one with the lowest latency the location of the device of the tunnel with given name.
+// The locations requiring a paid subscription are only probed when the billing feature of the user allows to use them.
+// Only the locations with a known endpoint are probed, so a warning is shown when they are a minority of the available ones.
+// It returns a SubscriptionError if none of the locations is available for the user.
+func (w AuthClientWrapper) SetFastestLocation(userID auth.ProfileID, name string, country string, timeout time.Duration) (LocationLatency, error) {
+	var fastest LocationLatency
+
+	locations, err := w.FindLocations(country)
+	if err != nil {
+		return fastest, err
+	}
+
+	if len(locations) == 0 {
+		return fastest, fmt.Errorf("no locations in country: %s", country)
+	}
+
+	b, err := w.GetUnexpiredOrMostRecentBillingFeature(userID)
+	if err != nil {
+		return fastest, err
+	}
+
+	allowed := make([]LocationWrapper, 0, len(locations))
+	for _, location := range locations {
+		if locationAllowed(b, location) {
+			allowed = append(allowed, location)
+		}
+	}
+
+	if len(allowed) == 0 {
+		return fastest, errPremiumLocation()
+	}
+
+	latencies, err := w.ProbeLocations(userID, allowed, timeout, false)
+	if err != nil {
+		return fastest, err
+	}
+
+	probed := probedLocations(latencies)
+	if len(probed) == 0 {
+		return fastest, ErrNoEndpoints
+	}
+
+	if !probed[0].Reachable {
+		return fastest, ErrNoReachableLocation
+	}
+
+	if len(probed)*2 < len(latencies) {
+		w.warn(fmt.Sprintf("The fastest location is picked among the %d of %d locations with a known endpoint only", len(probed), len(latencies)))
+	}
+
+	fastest = probed[0]
+	return fastest, w.setLocation(userID, name, fastest.Location, b)
+}
+
+// PingLocations is a method that probes the latency of the locations the argument resolves into with MatchLocations,
+// or of the locations available for the user optionally filtered by country when the argument is empty. The locations the billing feature of the user does not allow to use are
+// returned as Locked after the probed ones, without probing them.
+func (w AuthClientWrapper) PingLocations(userID auth.ProfileID, arg string, country string, timeout time.Duration, refresh bool) ([]LocationLatency, error) {
+	locations, err := w.FindLocations(country)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(arg) > 0 {
+		locations, err = MatchLocations(locations, arg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := w.GetUnexpiredOrMostRecentBillingFeature(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var allowed []LocationWrapper
+	var locked []LocationLatency
+	for _, location := range locations {
+		if locationAllowed(b, location) {
+			allowed = append(allowed, location)
+		} else {
+			locked = append(locked, LocationLatency{Location: location, Locked: true})
+		}
+	}
+
+	latencies, err := w.ProbeLocations(userID, allowed, timeout, refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(latencies, locked...), nil
+}
+
+// PrintLatencies is a function to render the locations along with the latencies they were probed with as a table.
+func PrintLatencies(latencies []LocationLatency) {
+	renderLocationsTable(os.Stdout, latencies, true)
+}
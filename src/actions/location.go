@@ -5,68 +5,261 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"time"
 
 	forestvpn_api "github.com/forestvpn/api-client-go"
 	"github.com/forestvpn/cli/auth"
 	"github.com/forestvpn/cli/utils"
-	"github.com/olekukonko/tablewriter"
 	"gopkg.in/ini.v1"
 )
 
 const Falkenstein = "b134d679-8697-4dc6-b629-c4c189392fca"
 const Helsinki = "7fc5b17c-eddf-413f-8b37-9d36eb5e33ec"
 
-// ListLocations is a function to get the list of locations available for user.
+// LocationSorts are the orders the locations can be listed in, the first one is the default.
+var LocationSorts = []string{"name", "country", "latency"}
+
+// ListOptions is a structure holding the filters and the order of the listed locations.
+type ListOptions struct {
+	// Country filters the locations by country, see MatchCountry.
+	Country string
+	// Premium keeps only the locations requiring a paid subscription.
+	Premium bool
+	// Free keeps only the locations available on the free plan.
+	Free bool
+	// Sort is one of LocationSorts. Sorting by latency probes the locations the subscription of the user allows to use.
+	Sort string
+}
+
+// Validate is a method to check the options before any location is requested.
+func (o ListOptions) Validate() error {
+	if o.Premium && o.Free {
+		return fmt.Errorf("either premium or free locations expected")
+	}
+
+	for _, sort := range LocationSorts {
+		if o.Sort == sort || len(o.Sort) == 0 {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown sort: %s, expected one of %v", o.Sort, LocationSorts)
+}
+
+// ListLocations is a function to print the list of locations available for user in given format, one of OutputFormats.
 //
 // See https://github.com/forestvpn/api-client-go/blob/main/docs/GeoApi.md#listlocations for more information.
-func (w AuthClientWrapper) ListLocations(country string) error {
-	var data [][]string
-
-	locations, err := w.ApiClient.GetLocations()
+func (w AuthClientWrapper) ListLocations(userID auth.ProfileID, options ListOptions, format string) error {
+	locations, err := w.QueryLocations(userID, options)
 	if err != nil {
 		return err
 	}
 
-	if len(country) > 0 {
-		locations = filterLocationsByCountry(locations, country)
+	return RenderLocations(os.Stdout, locations, format)
+}
+
+// QueryLocations is a method to get the locations available for user filtered and sorted according to the options.
+// The latencies are only probed, and the locations the subscription of the user does not allow to use marked Locked, when sorting by latency.
+func (w AuthClientWrapper) QueryLocations(userID auth.ProfileID, options ListOptions) ([]LocationLatency, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	var latencies []LocationLatency
+	if options.Sort == "latency" {
+		var err error
+		latencies, err = w.PingLocations(userID, "", options.Country, DefaultProbeTimeout, false)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		locations, err := w.FindLocations(options.Country)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, location := range locations {
+			latencies = append(latencies, LocationLatency{Location: location})
+		}
+	}
+
+	return FilterLocations(latencies, options), nil
+}
+
+// FilterLocations is a function that keeps the premium or the free locations, if requested by the options, and sorts them.
+// Sorting by latency puts the unreachable and the unprobed locations last.
+func FilterLocations(latencies []LocationLatency, options ListOptions) []LocationLatency {
+	filtered := make([]LocationLatency, 0, len(latencies))
+	for _, latency := range latencies {
+		if options.Premium && !latency.Location.Premium || options.Free && latency.Location.Premium {
+			continue
+		}
+		filtered = append(filtered, latency)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		a, b := filtered[i], filtered[j]
+		switch options.Sort {
+		case "country":
+			if a.Location.Location.Country.GetName() != b.Location.Location.Country.GetName() {
+				return a.Location.Location.Country.GetName() < b.Location.Location.Country.GetName()
+			}
+		case "latency":
+			if a.Reachable != b.Reachable {
+				return a.Reachable
+			}
+			if a.Reachable && a.RTT != b.RTT {
+				return a.RTT < b.RTT
+			}
+		}
+		return lessLocation(a.Location.Location, b.Location.Location)
+	})
+
+	return filtered
+}
+
+// FindLocations is a function to get the sorted locations available for user, optionally filtered by country.
+func (w AuthClientWrapper) FindLocations(country string) ([]LocationWrapper, error) {
+	locations, err := w.GetLocations()
+	if err != nil {
+		return nil, err
 	}
 
 	sortLocations(locations)
-	wrappedLocations := GetLocationWrappers(locations)
+	wrappers := GetLocationWrappers(locations)
+
+	if len(country) > 0 {
+		return MatchCountry(wrappers, country)
+	}
+
+	return wrappers, nil
+}
+
+// SetDefaultLocation is a function that resolves the location by UUID, city, country or ISO code, see MatchLocations,
+// checks the subscription of the user allows to use it and makes it the location of the device of the tunnel with given name, the default tunnel if the name is empty.
+// A named tunnel is created along with it's own device the first time it's location is set.
+// It returns a SubscriptionError if the location requires a paid subscription.
+func (w AuthClientWrapper) SetDefaultLocation(userID auth.ProfileID, name string, arg string) (LocationWrapper, error) {
+	var location LocationWrapper
+
+	locations, err := w.GetLocations()
+	if err != nil {
+		return location, err
+	}
+
+	b, err := w.GetUnexpiredOrMostRecentBillingFeature(userID)
+	if err != nil {
+		return location, err
+	}
+
+	location, err = resolveLocation(userID, GetLocationWrappers(locations), arg, b)
+	if err != nil {
+		return location, err
+	}
 
-	for _, loc := range wrappedLocations {
-		premiumMark := ""
-		if loc.Premium {
-			premiumMark = "*"
+	return location, w.setLocation(userID, name, location, b)
+}
+
+// setLocation is a method that checks the billing feature allows to use the location and makes it the location of the device
+// of the tunnel with given name, creating the named tunnel along with it's own device the first time.
+func (w AuthClientWrapper) setLocation(userID auth.ProfileID, name string, location LocationWrapper, b forestvpn_api.BillingFeature) error {
+	if !locationAllowed(b, location) {
+		return errPremiumLocation()
+	}
+
+	if len(name) > 0 && !auth.TunnelExists(userID, name) {
+		if err := w.createTunnel(userID, name); err != nil {
+			return err
 		}
-		data = append(data, []string{loc.Location.GetName(), loc.Location.Country.GetName(), loc.Location.GetId(), premiumMark})
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"City", "Country", "UUID", "Premium"})
-	table.SetBorder(false)
-	table.AppendBulk(data)
-	table.Render()
+	device, err := auth.LoadTunnelDevice(userID, name)
+	if err != nil {
+		return err
+	}
+
+	client, err := w.apiClient()
+	if err != nil {
+		return err
+	}
+
+	device, err = client.UpdateDevice(device.GetId(), location.Location.GetId())
+	if err != nil {
+		return err
+	}
+
+	err = auth.UpdateTunnelDevice(device, userID, name)
+	if err != nil {
+		return err
+	}
+
+	// The endpoint only serves probing the latency, failing to record it does not fail setting the location.
+	_ = recordEndpoint(userID, device)
+
+	if !utils.IsOpenWRT() {
+		err = auth.CreateWireguardConfigurationFile(device, userID, name)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-func filterLocationsByCountry(locations []forestvpn_api.Location, country string) []forestvpn_api.Location {
-	var locationsByCountry []forestvpn_api.Location
-	for _, location := range locations {
-		if strings.EqualFold(location.Country.GetName(), country) {
-			locationsByCountry = append(locationsByCountry, location)
-		}
+// locationAllowed reports whether the billing feature allows to use the location: the premium locations require a paid subscription
+// and no location is available once the billing feature is expired.
+func locationAllowed(b forestvpn_api.BillingFeature, location LocationWrapper) bool {
+	expired := time.Now().After(b.GetExpiryDate())
+	return !(location.Premium && b.GetBundleId() == "com.forestvpn.freemium" || expired)
+}
+
+// errPremiumLocation returns the SubscriptionError of a location which requires a paid subscription.
+func errPremiumLocation() error {
+	return &SubscriptionError{Message: fmt.Sprintf("The location you want to use is now unavailable, as it requires a paid subscription. You can unlock it by going Premium at %s.", CheckoutUrl)}
+}
+
+// createTunnel is a method that registers the named tunnel in the profile configuration and creates a device for it.
+func (w AuthClientWrapper) createTunnel(userID auth.ProfileID, name string) error {
+	config, err := auth.LoadConfig(userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := config.AddTunnel(name); err != nil {
+		return err
+	}
+
+	client, err := w.apiClient()
+	if err != nil {
+		return err
 	}
-	return locationsByCountry
+
+	device, err := client.CreateDevice()
+	if err != nil {
+		return err
+	}
+
+	if err := auth.UpdateTunnelDevice(device, userID, name); err != nil {
+		return err
+	}
+
+	return auth.SaveConfig(userID, config)
 }
 
 func sortLocations(locations []forestvpn_api.Location) {
 	sort.Slice(locations, func(i, j int) bool {
-		return locations[i].GetName() < locations[j].GetName() && locations[i].Country.GetName() < locations[j].Country.GetName()
+		return lessLocation(locations[i], locations[j])
 	})
 }
 
+// lessLocation orders the locations by city and then by country, for the cities sharing a name.
+func lessLocation(a, b forestvpn_api.Location) bool {
+	if a.GetName() != b.GetName() {
+		return a.GetName() < b.GetName()
+	}
+	return a.Country.GetName() < b.Country.GetName()
+}
+
 // Deprecated: SetLocation is a function that writes the location data into the Wireguard configuration file.
 // It uses gopkg.in/ini.v1 package to form Woreguard compatible configuration file from the location data.
 // If the user subscrition on the Forest VPN services is out of date, it calls BuyPremiumDialog.
@@ -6,7 +6,10 @@ package actions
 
 import (
 	"encoding/json"
+	"fmt"
 	"sort"
+	"sync"
+	"time"
 
 	forestvpn_api "github.com/forestvpn/api-client-go"
 	"github.com/forestvpn/cli/api"
@@ -18,6 +21,14 @@ import (
 type AuthClientWrapper struct {
 	ApiClient   *api.ApiClientWrapper
 	AccountsMap *auth.UserDB
+	// Locations fetches the locations for GetLocations, the ApiClient if nil.
+	Locations LocationsFetcher
+	// RefreshLocations makes GetLocations fetch the locations from the API even if the cached ones are fresh.
+	RefreshLocations bool
+	// Warn is called with the warnings to show to the user, e.g. the locations being used from the cache while the API is unreachable.
+	Warn func(message string)
+	// connect signs the profile in and returns it's API client once the API is first called, if the ApiClient is nil.
+	connect func() (*api.ApiClientWrapper, error)
 }
 
 func GetAuthClientWrapper(profile *auth.Profile, apiHost string) (AuthClientWrapper, error) {
@@ -25,7 +36,37 @@ func GetAuthClientWrapper(profile *auth.Profile, apiHost string) (AuthClientWrap
 	if err != nil {
 		return AuthClientWrapper{}, err
 	}
-	return AuthClientWrapper{ApiClient: api.GetApiClient(accessToken.Raw(), apiHost)}, nil
+	return AuthClientWrapper{ApiClient: api.GetApiClient(accessToken.Raw(), apiHost), Warn: PrintWarning}, nil
+}
+
+// GetLazyAuthClientWrapper is a factory function that returns an AuthClientWrapper signing the profile in only once the API is called,
+// so the commands served from the cache, e.g. 'fvpn location ls', work while the API is unreachable.
+// A profile never signed in yet is signed in right away, since it's ID is unknown until then.
+func GetLazyAuthClientWrapper(profile *auth.Profile, apiHost string) (AuthClientWrapper, error) {
+	if len(profile.ID) == 0 {
+		if err := profile.SignIn(apiHost); err != nil {
+			return AuthClientWrapper{}, err
+		}
+	}
+
+	connect := sync.OnceValues(func() (*api.ApiClientWrapper, error) {
+		if err := profile.SignIn(apiHost); err != nil {
+			return nil, err
+		}
+
+		w, err := GetAuthClientWrapper(profile, apiHost)
+		return w.ApiClient, err
+	})
+
+	return AuthClientWrapper{Warn: PrintWarning, connect: connect}, nil
+}
+
+// apiClient is a method returning the ApiClient, signing the profile in first if the wrapper is lazy.
+func (w AuthClientWrapper) apiClient() (*api.ApiClientWrapper, error) {
+	if w.ApiClient != nil || w.connect == nil {
+		return w.ApiClient, nil
+	}
+	return w.connect()
 }
 
 func (w AuthClientWrapper) GetUnexpiredOrMostRecentBillingFeature(userID auth.ProfileID) (forestvpn_api.BillingFeature, error) {
@@ -45,7 +86,12 @@ func (w AuthClientWrapper) GetUnexpiredOrMostRecentBillingFeature(userID auth.Pr
 		}
 	}
 
-	resp, err := w.ApiClient.GetBillingFeatures()
+	client, err := w.apiClient()
+	if err != nil {
+		return b, err
+	}
+
+	resp, err := client.GetBillingFeatures()
 	if err != nil {
 		return b, err
 	}
@@ -68,3 +114,38 @@ func (w AuthClientWrapper) GetUnexpiredOrMostRecentBillingFeature(userID auth.Pr
 
 	return billingFeatures[0], nil
 }
+
+// CheckoutUrl is a page to go Premium at.
+const CheckoutUrl = "https://forestvpn.com/checkout/"
+
+// SubscriptionError is returned when the subscription of the user does not allow to use the location.
+type SubscriptionError struct {
+	Message string
+}
+
+func (e *SubscriptionError) Error() string {
+	return e.Message
+}
+
+// CheckSubscription is a function that checks whether the billing feature allows to connect to the location.
+// It returns a SubscriptionError if it does not and a warning to show to the user if the subscription is about to end.
+func CheckSubscription(b forestvpn_api.BillingFeature, location forestvpn_api.Location) (string, error) {
+	bid := b.GetBundleId()
+	now := time.Now()
+	exp := b.GetExpiryDate()
+	left := exp.Sub(now)
+	days := int64(left.Hours() / 24)
+
+	if now.After(exp) {
+		if IsPremiumLocation(location) && bid == "com.forestvpn.premium" {
+			return "", &SubscriptionError{Message: fmt.Sprintf("The location you were using is now unavailable, as your paid subscription has ended.\nYou can keep using ForestVPN once you watch an ad in our mobile app, or simply go Premium at %s.", CheckoutUrl)}
+		}
+		return "", &SubscriptionError{Message: fmt.Sprintf("Your 30-minute session is over.\nYou can keep using ForestVPN once you watch an ad in our mobile app, or simply go Premium at %s.", CheckoutUrl)}
+	} else if bid == "com.forestvpn.freemium" && int(left.Minutes()) < 5 {
+		return "You currently have less than 5 minutes of free trial left.", nil
+	} else if days == 3 && left.Hours() == 0 || days < 3 && bid == "com.forestvpn.premium" {
+		return "Your premium subscription will end in less than 3 days.", nil
+	}
+
+	return "", nil
+}
@@ -0,0 +1,98 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/forestvpn/cli/auth"
+)
+
+// MinMTU is the lowest MTU of the tunnel, the minimum MTU of IPv6 carried by every tunnel.
+const MinMTU = 1280
+
+// MaxMTU is the highest MTU of the tunnel, the largest jumbo frame commonly supported.
+const MaxMTU = 9000
+
+// wireguardOverhead is the size of the IPv6, UDP and Wireguard headers around the packets of the tunnel, the same wg-quick subtracts.
+const wireguardOverhead = 80
+
+// ErrMTUDiscoveryUnsupported is returned by DiscoverMTU on the platforms unable to read the path MTU of a socket.
+var ErrMTUDiscoveryUnsupported = errors.New("MTU discovery is only supported on Linux")
+
+// DiscoverMTU is a function that probes the path MTU toward the endpoint of the tunnel with given name and stores the MTU of the tunnel
+// fitting into it, unless the MTU is pinned in the profile configuration.
+// It returns true if the stored MTU changed, in which case the Wireguard configuration file is written again.
+func DiscoverMTU(userID auth.ProfileID, name string) (bool, error) {
+	config, err := auth.LoadConfig(userID)
+	if err != nil || config.MTU > 0 {
+		return false, err
+	}
+
+	device, err := auth.LoadTunnelDevice(userID, name)
+	if err != nil {
+		return false, err
+	}
+
+	peers := device.Wireguard.GetPeers()
+	if len(peers) == 0 {
+		return false, fmt.Errorf("no peers to discover the MTU toward")
+	}
+
+	path, err := pathMTU(peers[0].GetEndpoint())
+	if err != nil {
+		return false, err
+	}
+
+	mtu := path - wireguardOverhead
+	if mtu < MinMTU {
+		mtu = MinMTU
+	} else if mtu > MaxMTU {
+		mtu = MaxMTU
+	}
+
+	current, err := auth.LoadMTU(userID, name)
+	if err != nil || current == mtu {
+		return false, err
+	}
+
+	if err := auth.SaveDiscoveredMTU(userID, name, mtu); err != nil {
+		return false, err
+	}
+
+	return true, writeTunnelConfig(userID, name)
+}
+
+// SetMTU is a function that pins the MTU of the tunnels of the profile, or lets it be discovered again if the MTU is 0.
+// The Wireguard configuration files of every tunnel are written again.
+func SetMTU(userID auth.ProfileID, mtu int) error {
+	if mtu != 0 && (mtu < MinMTU || mtu > MaxMTU) {
+		return fmt.Errorf("invalid MTU: %d, expected a value from %d to %d, or 0 to discover it", mtu, MinMTU, MaxMTU)
+	}
+
+	config, err := auth.LoadConfig(userID)
+	if err != nil {
+		return err
+	}
+
+	config.MTU = mtu
+	if err := auth.SaveConfig(userID, config); err != nil {
+		return err
+	}
+
+	names := []string{""}
+	for name := range config.Tunnels {
+		names = append(names, name)
+	}
+
+	for _, name := range names {
+		if !auth.TunnelExists(userID, name) {
+			continue
+		}
+
+		if err := writeTunnelConfig(userID, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
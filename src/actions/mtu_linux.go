@@ -0,0 +1,76 @@
+package actions
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// mtuProbes is how many times the path MTU is probed at most until it stops shrinking.
+const mtuProbes = 4
+
+// mtuProbeWait is how long the ICMP messages lowering the path MTU are waited for after every probe.
+const mtuProbeWait = 300 * time.Millisecond
+
+// pathMTU returns the path MTU toward the endpoint. It starts from the MTU of the route to the endpoint and sends probes
+// of the full size with fragmentation prohibited, so every router unable to forward them lowers the path MTU of the socket
+// with an ICMP message. The socket is marked with WireguardTable, so it is routed outside of the tunnels as the encrypted traffic is.
+func pathMTU(endpoint string) (int, error) {
+	addr, err := net.ResolveUDPAddr("udp", endpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	level, discover, option, headers := unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_MTU, 28
+	if addr.IP.To4() == nil {
+		level, discover, option, headers = unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_MTU, 48
+	}
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		// Marking the socket requires root privileges, without them the probes follow the routes of the tunnels.
+		_ = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, WireguardTable)
+		sockErr = unix.SetsockoptInt(int(fd), level, discover, unix.IP_PMTUDISC_DO)
+	})
+	if err != nil {
+		return 0, err
+	} else if sockErr != nil {
+		return 0, sockErr
+	}
+
+	mtu := 0
+	for i := 0; i < mtuProbes; i++ {
+		var current int
+		err = raw.Control(func(fd uintptr) {
+			current, sockErr = unix.GetsockoptInt(int(fd), level, option)
+		})
+		if err != nil {
+			return 0, err
+		} else if sockErr != nil {
+			return 0, sockErr
+		}
+
+		if current == mtu {
+			break
+		}
+		mtu = current
+
+		// The probe exceeding the known path MTU fails with EMSGSIZE, which is expected.
+		_, _ = conn.Write(make([]byte, mtu-headers))
+		time.Sleep(mtuProbeWait)
+	}
+
+	return mtu, nil
+}
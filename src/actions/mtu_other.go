@@ -0,0 +1,8 @@
+//go:build !linux
+
+package actions
+
+// pathMTU is not available outside of Linux, it returns ErrMTUDiscoveryUnsupported.
+func pathMTU(endpoint string) (int, error) {
+	return 0, ErrMTUDiscoveryUnsupported
+}
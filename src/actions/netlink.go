@@ -0,0 +1,10 @@
+package actions
+
+// NetlinkBackend configures the Wireguard interface directly over netlink without Wireguard tools installed.
+const NetlinkBackend = "netlink"
+
+func init() {
+	RegisterBackend(NetlinkBackend, func(iface string) TunnelBackend {
+		return netlinkBackend{name: iface}
+	})
+}
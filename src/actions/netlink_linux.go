@@ -0,0 +1,445 @@
+package actions
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/utils"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// WireguardTable is the firewall mark of the encrypted traffic of every tunnel and the routing table of the default tunnel routes,
+// the same wg-quick uses by default.
+const WireguardTable = auth.DefaultTable
+
+const persistentKeepalive = auth.PersistentKeepalive * time.Second
+
+// netlinkBackend configures a kernel Wireguard interface over rtnetlink and generic netlink without calling wg, wg-quick or ip.
+type netlinkBackend struct {
+	name string
+}
+
+// Up creates the interface, applies the device keys and peers, assigns the addresses and installs the routes.
+// The routes are placed in the table of the tunnel. For the default tunnel it comes with policy rules in the same manner as wg-quick does,
+// so the encrypted traffic marked with the firewall mark keeps using the main routing table.
+// The other tunnels only get the rules routing the traffic from their addresses.
+// On failure the interface is removed.
+func (t netlinkBackend) Up(config TunnelConfig) (err error) {
+	device := config.Device
+	link := &netlink.GenericLink{LinkAttrs: netlink.LinkAttrs{Name: t.name}, LinkType: "wireguard"}
+	if err := netlink.LinkAdd(link); err != nil {
+		return &TunnelError{Op: "create link", Interface: t.name, Err: err}
+	}
+
+	defer func() {
+		if err != nil {
+			_ = t.Down(config)
+		}
+	}()
+
+	if err := t.configure(config, nil); err != nil {
+		return err
+	}
+
+	created, err := netlink.LinkByName(t.name)
+	if err != nil {
+		return &TunnelError{Op: "find link", Interface: t.name, Err: err}
+	}
+
+	for _, ip := range device.GetIps() {
+		addr, err := netlink.ParseAddr(utils.HostPrefix(ip))
+		if err != nil {
+			return &TunnelError{Op: "parse address", Interface: t.name, Err: err}
+		}
+
+		if err := netlink.AddrAdd(created, addr); err != nil && !errors.Is(err, unix.EEXIST) {
+			return &TunnelError{Op: "add address", Interface: t.name, Err: err}
+		}
+	}
+
+	if config.MTU > 0 {
+		if err := netlink.LinkSetMTU(created, config.MTU); err != nil {
+			return &TunnelError{Op: "set MTU", Interface: t.name, Err: err}
+		}
+	}
+
+	if err := netlink.LinkSetUp(created); err != nil {
+		return &TunnelError{Op: "set link up", Interface: t.name, Err: err}
+	}
+
+	families := make(map[int]bool)
+	for _, peer := range device.Wireguard.GetPeers() {
+		networks, err := t.allowedNetworks(peer, config)
+		if err != nil {
+			return err
+		}
+
+		for i := range networks {
+			route := &netlink.Route{LinkIndex: created.Attrs().Index, Dst: &networks[i], Table: config.Table}
+			if err := netlink.RouteReplace(route); err != nil {
+				return &TunnelError{Op: "add route", Interface: t.name, Err: err}
+			}
+			families[familyOf(networks[i].IP)] = true
+		}
+	}
+
+	var rules []*netlink.Rule
+	if config.Default {
+		for family := range families {
+			rules = append(rules, policyRules(family)...)
+		}
+	} else if rules, err = sourceRules(device, config.Table); err != nil {
+		return &TunnelError{Op: "parse address", Interface: t.name, Err: err}
+	}
+
+	for _, rule := range rules {
+		if err := netlink.RuleAdd(rule); err != nil && !errors.Is(err, unix.EEXIST) {
+			return &TunnelError{Op: "add rule", Interface: t.name, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// UpdatePeers replaces the peers of the interface with the peers of the device, keeping the current endpoints unless resolve is true.
+func (t netlinkBackend) UpdatePeers(config TunnelConfig, resolve bool) error {
+	endpoints := make(map[wgtypes.Key]*net.UDPAddr)
+
+	if !resolve {
+		client, err := wgctrl.New()
+		if err != nil {
+			return &TunnelError{Op: "open wireguard control", Interface: t.name, Err: err}
+		}
+		defer client.Close()
+
+		current, err := client.Device(t.name)
+		if err != nil {
+			return &TunnelError{Op: "get device", Interface: t.name, Err: err}
+		}
+
+		for _, peer := range current.Peers {
+			if peer.Endpoint != nil {
+				endpoints[peer.PublicKey] = peer.Endpoint
+			}
+		}
+	}
+
+	return t.configure(config, endpoints)
+}
+
+// UpdateRoutes applies the allowed IPs of the peers to the interface without replacing the peers, so their sessions are kept,
+// and replaces the routes of the interface in the table of the tunnel with the allowed IPs.
+func (t netlinkBackend) UpdateRoutes(config TunnelConfig) error {
+	client, err := wgctrl.New()
+	if err != nil {
+		return &TunnelError{Op: "open wireguard control", Interface: t.name, Err: err}
+	}
+	defer client.Close()
+
+	var peers []wgtypes.PeerConfig
+	wanted := make(map[string]net.IPNet)
+	for _, peer := range config.Device.Wireguard.GetPeers() {
+		publicKey, err := wgtypes.ParseKey(peer.GetPubKey())
+		if err != nil {
+			return &TunnelError{Op: "parse public key", Interface: t.name, Err: err}
+		}
+
+		networks, err := t.allowedNetworks(peer, config)
+		if err != nil {
+			return err
+		}
+
+		peers = append(peers, wgtypes.PeerConfig{PublicKey: publicKey, UpdateOnly: true, ReplaceAllowedIPs: true, AllowedIPs: networks})
+		for _, network := range networks {
+			wanted[network.String()] = network
+		}
+	}
+
+	if err := client.ConfigureDevice(t.name, wgtypes.Config{Peers: peers}); err != nil {
+		return &TunnelError{Op: "configure device", Interface: t.name, Err: err}
+	}
+
+	link, err := netlink.LinkByName(t.name)
+	if err != nil {
+		return &TunnelError{Op: "find link", Interface: t.name, Err: err}
+	}
+
+	filter := &netlink.Route{LinkIndex: link.Attrs().Index, Table: config.Table}
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		routes, err := netlink.RouteListFiltered(family, filter, netlink.RT_FILTER_OIF|netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return &TunnelError{Op: "list routes", Interface: t.name, Err: err}
+		}
+
+		for i := range routes {
+			// The default route comes without the destination.
+			destination := "0.0.0.0/0"
+			if routes[i].Dst != nil {
+				destination = routes[i].Dst.String()
+			} else if family == netlink.FAMILY_V6 {
+				destination = "::/0"
+			}
+
+			if _, ok := wanted[destination]; ok {
+				delete(wanted, destination)
+			} else if err := netlink.RouteDel(&routes[i]); err != nil && !errors.Is(err, unix.ESRCH) {
+				return &TunnelError{Op: "delete route", Interface: t.name, Err: err}
+			}
+		}
+	}
+
+	for _, network := range wanted {
+		network := network
+		route := &netlink.Route{LinkIndex: link.Attrs().Index, Dst: &network, Table: config.Table}
+		if err := netlink.RouteReplace(route); err != nil {
+			return &TunnelError{Op: "add route", Interface: t.name, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// configure applies the private key, firewall mark and peers of the device to the interface.
+// The endpoints of the peers are resolved unless they are given in the endpoints map or pinned in the config.
+func (t netlinkBackend) configure(config TunnelConfig, endpoints map[wgtypes.Key]*net.UDPAddr) error {
+	device := config.Device
+	client, err := wgctrl.New()
+	if err != nil {
+		return &TunnelError{Op: "open wireguard control", Interface: t.name, Err: err}
+	}
+	defer client.Close()
+
+	privateKey, err := wgtypes.ParseKey(device.Wireguard.GetPrivKey())
+	if err != nil {
+		return &TunnelError{Op: "parse private key", Interface: t.name, Err: err}
+	}
+
+	var peers []wgtypes.PeerConfig
+	keepalive := persistentKeepalive
+	for _, peer := range device.Wireguard.GetPeers() {
+		publicKey, err := wgtypes.ParseKey(peer.GetPubKey())
+		if err != nil {
+			return &TunnelError{Op: "parse public key", Interface: t.name, Err: err}
+		}
+
+		networks, err := t.allowedNetworks(peer, config)
+		if err != nil {
+			return err
+		}
+
+		endpoint, ok := endpoints[publicKey]
+		if !ok {
+			endpoint, ok = config.Endpoints[peer.GetEndpoint()]
+		}
+		if !ok {
+			endpoint, err = net.ResolveUDPAddr("udp", peer.GetEndpoint())
+			if err != nil {
+				return &TunnelError{Op: "resolve endpoint", Interface: t.name, Err: err}
+			}
+		}
+
+		peerConfig := wgtypes.PeerConfig{
+			PublicKey:                   publicKey,
+			Endpoint:                    endpoint,
+			PersistentKeepaliveInterval: &keepalive,
+			ReplaceAllowedIPs:           true,
+			AllowedIPs:                  networks,
+		}
+
+		if len(peer.GetPsKey()) > 0 {
+			presharedKey, err := wgtypes.ParseKey(peer.GetPsKey())
+			if err != nil {
+				return &TunnelError{Op: "parse preshared key", Interface: t.name, Err: err}
+			}
+			peerConfig.PresharedKey = &presharedKey
+		}
+
+		peers = append(peers, peerConfig)
+	}
+
+	mark := WireguardTable
+	err = client.ConfigureDevice(t.name, wgtypes.Config{
+		PrivateKey:   &privateKey,
+		FirewallMark: &mark,
+		ReplacePeers: true,
+		Peers:        peers,
+	})
+	if err != nil {
+		return &TunnelError{Op: "configure device", Interface: t.name, Err: err}
+	}
+
+	return nil
+}
+
+// allowedNetworks parses the allowed IPs of the peer merged with the split tunneling networks of the profile.
+func (t netlinkBackend) allowedNetworks(peer forestvpn_api.WireGuardPeer, config TunnelConfig) ([]net.IPNet, error) {
+	allowedIPs, err := peerAllowedIPs(peer, config)
+	if err != nil {
+		return nil, &TunnelError{Op: "split tunnel", Interface: t.name, Err: err}
+	}
+
+	var networks []net.IPNet
+	for _, cidr := range allowedIPs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, &TunnelError{Op: "parse allowed ip", Interface: t.name, Err: err}
+		}
+		networks = append(networks, *network)
+	}
+
+	return networks, nil
+}
+
+// Down removes the rules and the interface. The routes of the table are removed by the kernel along with the interface.
+func (t netlinkBackend) Down(config TunnelConfig) error {
+	var rules []*netlink.Rule
+	if config.Default {
+		rules = append(policyRules(netlink.FAMILY_V4), policyRules(netlink.FAMILY_V6)...)
+	} else {
+		rules, _ = sourceRules(config.Device, config.Table)
+	}
+
+	for _, rule := range rules {
+		_ = netlink.RuleDel(rule)
+	}
+
+	link, err := netlink.LinkByName(t.name)
+	if err != nil {
+		return &TunnelError{Op: "find link", Interface: t.name, Err: err}
+	}
+
+	if err := netlink.LinkDel(link); err != nil {
+		return &TunnelError{Op: "delete link", Interface: t.name, Err: err}
+	}
+
+	return nil
+}
+
+// Status reports whether the Wireguard interface exists in the kernel.
+func (t netlinkBackend) Status() (bool, error) {
+	client, err := wgctrl.New()
+	if err != nil {
+		return false, &TunnelError{Op: "open wireguard control", Interface: t.name, Err: err}
+	}
+	defer client.Close()
+
+	_, err = client.Device(t.name)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, &TunnelError{Op: "get device", Interface: t.name, Err: err}
+	}
+
+	return true, nil
+}
+
+// Stats reads the stats of the first peer of the interface over generic netlink.
+func (t netlinkBackend) Stats() (TunnelStats, error) {
+	var stats TunnelStats
+
+	client, err := wgctrl.New()
+	if err != nil {
+		return stats, &TunnelError{Op: "open wireguard control", Interface: t.name, Err: err}
+	}
+	defer client.Close()
+
+	device, err := client.Device(t.name)
+	if err != nil {
+		return stats, &TunnelError{Op: "get device", Interface: t.name, Err: err}
+	}
+
+	if len(device.Peers) == 0 {
+		return stats, &TunnelError{Op: "get peers", Interface: t.name, Err: os.ErrNotExist}
+	}
+
+	peer := device.Peers[0]
+	if peer.Endpoint != nil {
+		stats.Endpoint = peer.Endpoint.String()
+	}
+	stats.LatestHandshake = peer.LastHandshakeTime
+	stats.RxBytes = peer.ReceiveBytes
+	stats.TxBytes = peer.TransmitBytes
+	for _, network := range peer.AllowedIPs {
+		stats.AllowedIPs = append(stats.AllowedIPs, network.String())
+	}
+
+	return stats, nil
+}
+
+// policyRules returns the rules routing everything except the traffic marked with the WireguardTable firewall mark through the WireguardTable,
+// while still honoring the more specific routes of the main table.
+func policyRules(family int) []*netlink.Rule {
+	tunnel := netlink.NewRule()
+	tunnel.Family = family
+	tunnel.Table = WireguardTable
+	tunnel.Mark = WireguardTable
+	tunnel.Invert = true
+	tunnel.Priority = 32764
+
+	suppress := netlink.NewRule()
+	suppress.Family = family
+	suppress.Table = unix.RT_TABLE_MAIN
+	suppress.SuppressPrefixlen = 0
+	suppress.Priority = 32763
+
+	return []*netlink.Rule{tunnel, suppress}
+}
+
+// sourceRules returns the rules routing the traffic from the addresses of the device through the table.
+// They precede the policyRules of the default tunnel.
+func sourceRules(device *forestvpn_api.Device, table int) ([]*netlink.Rule, error) {
+	var rules []*netlink.Rule
+	for _, ip := range device.GetIps() {
+		_, network, err := net.ParseCIDR(utils.HostPrefix(ip))
+		if err != nil {
+			return nil, err
+		}
+
+		rule := netlink.NewRule()
+		rule.Family = familyOf(network.IP)
+		rule.Src = network
+		rule.Table = table
+		rule.Priority = 32000
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// routeThroughHop routes the addresses the endpoints of the device of the default tunnel resolved to through the interface in the main table.
+// The encrypted traffic of the default tunnel is looked up in the main table, so it leaves through the interface.
+func routeThroughHop(endpoints map[string]*net.UDPAddr, iface string) error {
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		return &TunnelError{Op: "find link", Interface: iface, Err: err}
+	}
+
+	for _, endpoint := range endpointAddresses(endpoints) {
+		_, destination, err := net.ParseCIDR(utils.HostPrefix(endpoint.IP.String()))
+		if err != nil {
+			return err
+		}
+
+		route := &netlink.Route{LinkIndex: link.Attrs().Index, Dst: destination, Scope: netlink.SCOPE_LINK}
+		if err := netlink.RouteReplace(route); err != nil {
+			return &TunnelError{Op: "route endpoint", Interface: iface, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func familyOf(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
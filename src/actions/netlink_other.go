@@ -0,0 +1,35 @@
+//go:build !linux
+
+package actions
+
+import "net"
+
+// netlinkBackend is not available outside of Linux, every method returns ErrNetlinkUnsupported.
+type netlinkBackend struct {
+	name string
+}
+
+func (t netlinkBackend) Up(config TunnelConfig) error {
+	return ErrNetlinkUnsupported
+}
+
+func (t netlinkBackend) Down(config TunnelConfig) error {
+	return ErrNetlinkUnsupported
+}
+
+func (t netlinkBackend) Status() (bool, error) {
+	return false, ErrNetlinkUnsupported
+}
+
+func (t netlinkBackend) Stats() (TunnelStats, error) {
+	return TunnelStats{}, ErrNetlinkUnsupported
+}
+
+func (t netlinkBackend) UpdatePeers(config TunnelConfig, resolve bool) error {
+	return ErrNetlinkUnsupported
+}
+
+// routeThroughHop returns ErrMultiHopUnsupported, as multi-hop connections are only supported on Linux.
+func routeThroughHop(endpoints map[string]*net.UDPAddr, iface string) error {
+	return ErrMultiHopUnsupported
+}
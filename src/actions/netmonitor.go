@@ -0,0 +1,121 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/goauthlib/pkg/logger"
+)
+
+// DefaultNetworkDebounce is how long the network of the host has to stay unchanged by default before the tunnel is reconnected,
+// so a short flap, e.g. of a Wi-Fi link, results into a single reconnect.
+const DefaultNetworkDebounce = 3 * time.Second
+
+// routeChangeGrace is how long the deleted routes of a table are ignored after fvpn changed them, as rtnetlink reports them asynchronously.
+const routeChangeGrace = time.Second
+
+// routeChanges tracks the tables whose routes fvpn is changing, so the NetworkMonitor does not reconnect after the routes deleted
+// by fvpn itself, e.g. by the DomainRefresher or the Watchdog re-creating the interface.
+var routeChanges = struct {
+	sync.Mutex
+	pending map[int]int
+	done    map[int]time.Time
+}{pending: make(map[int]int), done: make(map[int]time.Time)}
+
+// changingRoutes is a function that marks the routes of the table as being changed by fvpn until the returned function is called.
+func changingRoutes(table int) func() {
+	routeChanges.Lock()
+	defer routeChanges.Unlock()
+	routeChanges.pending[table]++
+
+	return func() {
+		routeChanges.Lock()
+		defer routeChanges.Unlock()
+		routeChanges.pending[table]--
+		routeChanges.done[table] = time.Now()
+	}
+}
+
+// changedRoutes reports whether fvpn is changing the routes of the table or changed them less than routeChangeGrace ago.
+func changedRoutes(table int) bool {
+	routeChanges.Lock()
+	defer routeChanges.Unlock()
+	return routeChanges.pending[table] > 0 || time.Since(routeChanges.done[table]) < routeChangeGrace
+}
+
+// ErrNetworkMonitorUnsupported is returned on the platforms without rtnetlink to subscribe to the changes of the routes and links.
+var ErrNetworkMonitorUnsupported = errors.New("network monitoring is only supported on Linux")
+
+// NetworkMonitor is a structure that reconnects the tunnel once the network of the host changes, e.g. the laptop roams to another Wi-Fi network
+// or resumes from suspend. The changes are the default route of the host, the routes of the table of the tunnel
+// and the links other than the Wireguard ones going up or down.
+type NetworkMonitor struct {
+	State  State
+	UserID auth.ProfileID
+	// Debounce is how long the network has to stay unchanged before the tunnel is reconnected.
+	Debounce time.Duration
+	// Locker, if set, is held during every reconnect, e.g. to not interfere with setting the connection down.
+	Locker sync.Locker
+	Logger logger.Logger
+}
+
+// NewNetworkMonitor is a factory function that returns a NetworkMonitor of the State with the default debounce logging through auth.SimpleLogger.
+func NewNetworkMonitor(state State, userID auth.ProfileID) *NetworkMonitor {
+	return &NetworkMonitor{
+		State:    state,
+		UserID:   userID,
+		Debounce: DefaultNetworkDebounce,
+		Logger:   auth.NewSimpleLogger(),
+	}
+}
+
+// Run is a method that reconnects the tunnel every time the network settles after a change until the context is done.
+func (m *NetworkMonitor) Run(ctx context.Context) {
+	log := m.Logger.WithField("interface", m.State.WiregaurdInterface)
+
+	changes := make(chan string, 16)
+	if err := watchNetwork(ctx, m.State.Table, changes); err != nil {
+		log.WithError(err).Debugf("failed to watch the network: %+v", err)
+		return
+	}
+
+	var settled <-chan time.Time
+	var reason string
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case reason = <-changes:
+			settled = time.After(m.Debounce)
+		case <-settled:
+			settled = nil
+			if m.Locker != nil {
+				m.Locker.Lock()
+			}
+			if ctx.Err() == nil {
+				m.reconnect(reason)
+			}
+			if m.Locker != nil {
+				m.Locker.Unlock()
+			}
+		}
+	}
+}
+
+// reconnect is a method that reconnects the tunnel after the network changed for the reason.
+func (m *NetworkMonitor) reconnect(reason string) {
+	log := m.Logger.WithField("interface", m.State.WiregaurdInterface)
+
+	if !m.State.GetStatus() {
+		return
+	}
+
+	log.Infof("%s, reconnecting", reason)
+	if err := m.State.Reconnect(m.UserID); err != nil {
+		log.WithError(err).Errorf("reconnect failed: %+v", err)
+	}
+}
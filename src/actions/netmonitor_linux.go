@@ -0,0 +1,125 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// watchNetwork is a function that subscribes to the changes of the routes and links of the host and sends the reason of every relevant change
+// to the channel until the context is done. The relevant changes are the default routes of the main table, the routes of the table
+// deleted from under the tunnel, and the links other than the Wireguard ones going up or down.
+func watchNetwork(ctx context.Context, table int, changes chan<- string) error {
+	routes := make(chan netlink.RouteUpdate)
+	links := make(chan netlink.LinkUpdate)
+
+	if err := netlink.RouteSubscribeWithOptions(routes, ctx.Done(), netlink.RouteSubscribeOptions{}); err != nil {
+		return err
+	}
+
+	if err := netlink.LinkSubscribeWithOptions(links, ctx.Done(), netlink.LinkSubscribeOptions{}); err != nil {
+		return err
+	}
+
+	running := make(map[int]bool)
+	if current, err := netlink.LinkList(); err == nil {
+		for _, link := range current {
+			running[link.Attrs().Index] = isRunning(link)
+		}
+	}
+
+	go func() {
+		for {
+			var reason string
+
+			select {
+			case <-ctx.Done():
+				return
+			case update, ok := <-routes:
+				if !ok {
+					return
+				}
+				reason = routeChange(update, table)
+			case update, ok := <-links:
+				if !ok {
+					return
+				}
+
+				attrs := update.Link.Attrs()
+				if update.Link.Type() == "wireguard" {
+					continue
+				}
+
+				up := isRunning(update.Link) && update.Header.Type != unix.RTM_DELLINK
+				if up != running[attrs.Index] {
+					reason = fmt.Sprintf("link %s went down", attrs.Name)
+					if up {
+						reason = fmt.Sprintf("link %s went up", attrs.Name)
+					}
+				}
+				running[attrs.Index] = up
+			}
+
+			if len(reason) == 0 {
+				continue
+			}
+
+			select {
+			case changes <- reason:
+			default:
+			}
+		}
+	}()
+
+	return nil
+}
+
+// routeChange returns the reason to reconnect for the route update, empty if the update is not relevant.
+// The routes of the Wireguard interfaces in the main table, e.g. of the endpoints routed through the HopTunnel, are not relevant,
+// neither are the routes of the table deleted by fvpn itself.
+func routeChange(update netlink.RouteUpdate, table int) string {
+	deleted := update.Type == unix.RTM_DELROUTE
+
+	if update.Table == table && deleted && !changedRoutes(table) {
+		return fmt.Sprintf("route %s of the tunnel was deleted", routeDst(update.Dst))
+	}
+
+	if update.Table != unix.RT_TABLE_MAIN || !isDefaultRoute(update.Dst) {
+		return ""
+	}
+
+	if link, err := netlink.LinkByIndex(update.LinkIndex); err == nil && link.Type() == "wireguard" {
+		return ""
+	}
+
+	if deleted {
+		return fmt.Sprintf("default route via %s was deleted", update.Gw)
+	}
+	return fmt.Sprintf("default route via %s was added", update.Gw)
+}
+
+// isDefaultRoute reports whether the destination of the route is the whole address family.
+func isDefaultRoute(dst *net.IPNet) bool {
+	if dst == nil {
+		return true
+	}
+	ones, _ := dst.Mask.Size()
+	return ones == 0
+}
+
+// routeDst renders the destination of the route, the default route having none.
+func routeDst(dst *net.IPNet) string {
+	if dst == nil {
+		return "default"
+	}
+	return dst.String()
+}
+
+// isRunning reports whether the link is up and has a carrier.
+func isRunning(link netlink.Link) bool {
+	flags := link.Attrs().RawFlags
+	return flags&unix.IFF_UP != 0 && flags&unix.IFF_RUNNING != 0
+}
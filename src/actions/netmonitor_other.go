@@ -0,0 +1,10 @@
+//go:build !linux
+
+package actions
+
+import "context"
+
+// watchNetwork is not available outside of Linux, it returns ErrNetworkMonitorUnsupported.
+func watchNetwork(ctx context.Context, table int, changes chan<- string) error {
+	return ErrNetworkMonitorUnsupported
+}
@@ -0,0 +1,240 @@
+//go:build linux
+
+package actions
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+	"github.com/forestvpn/cli/utils"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// execIsolated is a function that runs the command in a new network namespace whose only connectivity is a Wireguard interface of the device.
+// The interface is created and configured in the current namespace, so it's encrypted traffic leaves through the host, and then moved to the new one,
+// where it gets the addresses of the device and the routes to the allowed IPs of the peers. The routing table of the host is not touched.
+// The command also gets a mount namespace with the DNS servers of the device bind-mounted over /etc/resolv.conf.
+// The namespaces and the interface are gone once the command exits.
+func execIsolated(device *forestvpn_api.Device, args []string, credential *syscall.Credential) error {
+	iface := fmt.Sprintf("fvpnx%d", os.Getpid())
+	link := &netlink.GenericLink{LinkAttrs: netlink.LinkAttrs{Name: iface}, LinkType: "wireguard"}
+	if err := netlink.LinkAdd(link); err != nil {
+		return &TunnelError{Op: "create link", Interface: iface, Err: err}
+	}
+	// Once moved, the interface is removed along with the namespace instead.
+	defer func() {
+		if created, err := netlink.LinkByName(iface); err == nil {
+			_ = netlink.LinkDel(created)
+		}
+	}()
+
+	if err := configureIsolated(iface, device); err != nil {
+		return err
+	}
+
+	resolvConf, err := os.CreateTemp("", "fvpn-resolv-*.conf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(resolvConf.Name())
+
+	for _, dns := range device.GetDns() {
+		fmt.Fprintf(resolvConf, "nameserver %s\n", dns)
+	}
+	if err := resolvConf.Close(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		// The thread is left locked, so it is destroyed along with it's namespaces once the goroutine returns.
+		runtime.LockOSThread()
+		done <- runIsolated(iface, device, resolvConf.Name(), args, credential)
+	}()
+
+	return <-done
+}
+
+// configureIsolated applies the private key and the peers of the device to the interface.
+// Unlike the tunnels, the allowed IPs of the peers are used as is, since the namespace has no other connectivity to split.
+func configureIsolated(iface string, device *forestvpn_api.Device) error {
+	client, err := wgctrl.New()
+	if err != nil {
+		return &TunnelError{Op: "open wireguard control", Interface: iface, Err: err}
+	}
+	defer client.Close()
+
+	privateKey, err := wgtypes.ParseKey(device.Wireguard.GetPrivKey())
+	if err != nil {
+		return &TunnelError{Op: "parse private key", Interface: iface, Err: err}
+	}
+
+	var peers []wgtypes.PeerConfig
+	keepalive := persistentKeepalive
+	for _, peer := range device.Wireguard.GetPeers() {
+		publicKey, err := wgtypes.ParseKey(peer.GetPubKey())
+		if err != nil {
+			return &TunnelError{Op: "parse public key", Interface: iface, Err: err}
+		}
+
+		endpoint, err := net.ResolveUDPAddr("udp", peer.GetEndpoint())
+		if err != nil {
+			return &TunnelError{Op: "resolve endpoint", Interface: iface, Err: err}
+		}
+
+		networks, err := isolatedNetworks(peer)
+		if err != nil {
+			return &TunnelError{Op: "parse allowed ip", Interface: iface, Err: err}
+		}
+
+		peerConfig := wgtypes.PeerConfig{
+			PublicKey:                   publicKey,
+			Endpoint:                    endpoint,
+			PersistentKeepaliveInterval: &keepalive,
+			AllowedIPs:                  networks,
+		}
+
+		if len(peer.GetPsKey()) > 0 {
+			presharedKey, err := wgtypes.ParseKey(peer.GetPsKey())
+			if err != nil {
+				return &TunnelError{Op: "parse preshared key", Interface: iface, Err: err}
+			}
+			peerConfig.PresharedKey = &presharedKey
+		}
+
+		peers = append(peers, peerConfig)
+	}
+
+	if err := client.ConfigureDevice(iface, wgtypes.Config{PrivateKey: &privateKey, ReplacePeers: true, Peers: peers}); err != nil {
+		return &TunnelError{Op: "configure device", Interface: iface, Err: err}
+	}
+
+	return nil
+}
+
+// isolatedNetworks parses the allowed IPs of the peer.
+func isolatedNetworks(peer forestvpn_api.WireGuardPeer) ([]net.IPNet, error) {
+	var networks []net.IPNet
+	for _, cidr := range peer.GetAllowedIps() {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, *network)
+	}
+	return networks, nil
+}
+
+// runIsolated moves the calling thread into new network and mount namespaces, moves the interface there, sets it up and runs the command.
+// It must be called on a locked thread which is never unlocked.
+func runIsolated(iface string, device *forestvpn_api.Device, resolvConf string, args []string, credential *syscall.Credential) error {
+	host, err := os.Open("/proc/thread-self/ns/net")
+	if err != nil {
+		return err
+	}
+	defer host.Close()
+
+	if err := unix.Unshare(unix.CLONE_NEWNET | unix.CLONE_NEWNS); err != nil {
+		return fmt.Errorf("failed to create namespace: %w", err)
+	}
+
+	isolated, err := os.Open("/proc/thread-self/ns/net")
+	if err != nil {
+		return err
+	}
+	defer isolated.Close()
+
+	// The interface is only visible in the namespace of the host, so it is moved from there.
+	if err := unix.Setns(int(host.Fd()), unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("failed to enter namespace: %w", err)
+	}
+
+	link, err := netlink.LinkByName(iface)
+	if err == nil {
+		err = netlink.LinkSetNsFd(link, int(isolated.Fd()))
+	}
+
+	if err := unix.Setns(int(isolated.Fd()), unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("failed to enter namespace: %w", err)
+	}
+
+	if err != nil {
+		return &TunnelError{Op: "move link", Interface: iface, Err: err}
+	}
+
+	if err := setUpIsolated(iface, device); err != nil {
+		return err
+	}
+
+	// The mounts are private to the namespace of the command and are gone along with it.
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+
+	if len(device.GetDns()) > 0 {
+		if err := unix.Mount(resolvConf, "/etc/resolv.conf", "", unix.MS_BIND, ""); err != nil {
+			return fmt.Errorf("failed to mount resolv.conf: %w", err)
+		}
+	}
+
+	command := exec.Command(args[0], args[1:]...)
+	command.Stdin, command.Stdout, command.Stderr = os.Stdin, os.Stdout, os.Stderr
+	command.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+	return command.Run()
+}
+
+// setUpIsolated sets the loopback and the interface up, assigns the addresses of the device to the interface and routes the allowed IPs of the peers through it.
+func setUpIsolated(iface string, device *forestvpn_api.Device) error {
+	loopback, err := netlink.LinkByName("lo")
+	if err != nil {
+		return &TunnelError{Op: "find link", Interface: "lo", Err: err}
+	}
+
+	if err := netlink.LinkSetUp(loopback); err != nil {
+		return &TunnelError{Op: "set link up", Interface: "lo", Err: err}
+	}
+
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		return &TunnelError{Op: "find link", Interface: iface, Err: err}
+	}
+
+	for _, ip := range device.GetIps() {
+		addr, err := netlink.ParseAddr(utils.HostPrefix(ip))
+		if err != nil {
+			return &TunnelError{Op: "parse address", Interface: iface, Err: err}
+		}
+
+		if err := netlink.AddrAdd(link, addr); err != nil {
+			return &TunnelError{Op: "add address", Interface: iface, Err: err}
+		}
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return &TunnelError{Op: "set link up", Interface: iface, Err: err}
+	}
+
+	for _, peer := range device.Wireguard.GetPeers() {
+		networks, err := isolatedNetworks(peer)
+		if err != nil {
+			return &TunnelError{Op: "parse allowed ip", Interface: iface, Err: err}
+		}
+
+		for i := range networks {
+			if err := netlink.RouteReplace(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: &networks[i]}); err != nil {
+				return &TunnelError{Op: "add route", Interface: iface, Err: err}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package actions
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/forestvpn/cli/utils"
+)
+
+// OpenWRTBackend configures the Wireguard interface with UCI when the connection is persistent and with wg and ip shell commands otherwise.
+const OpenWRTBackend = "openwrt"
+
+func init() {
+	RegisterBackend(OpenWRTBackend, func(iface string) TunnelBackend {
+		return openWRTBackend{iface: iface}
+	})
+}
+
+type openWRTBackend struct {
+	iface string
+}
+
+// isPersistent reports whether the connection is configured with UCI to persist through reboots.
+func (b openWRTBackend) isPersistent() bool {
+	return exec.Command("uci", "-q", "get", fmt.Sprintf("network.%s", b.iface)).Run() == nil
+}
+
+func (b openWRTBackend) Up(config TunnelConfig) error {
+	if !config.Default {
+		return errNamedTunnel(OpenWRTBackend)
+	}
+
+	device := config.Device
+	IPs := device.GetIps()
+
+	if config.Persist {
+		err := utils.Firewall(b.iface)
+		if err != nil {
+			return err
+		}
+
+		peer := device.Wireguard.GetPeers()[0]
+		endpoint := strings.Split(peer.GetEndpoint(), ":")
+		allowedIPs, err := peerAllowedIPs(peer, config)
+		if err != nil {
+			return err
+		}
+
+		return utils.Network(b.iface, device.Wireguard.GetPrivKey(), IPs, peer.GetPubKey(), peer.GetPsKey(), endpoint[0], endpoint[1], allowedIPs, config.MTU)
+	}
+
+	err := exec.Command("ip", "link", "add", "dev", b.iface, "type", "wireguard").Run()
+	if err != nil {
+		return err
+	}
+
+	err = exec.Command("ip", "address", "add", "dev", b.iface, IPs[1]).Run()
+	if err != nil {
+		return err
+	}
+
+	err = exec.Command("ip", "-6", "address", "add", "dev", b.iface, IPs[2]).Run()
+	if err != nil {
+		return err
+	}
+
+	err = exec.Command("wg", "setconf", b.iface, config.ConfigPath).Run()
+	if err != nil {
+		return err
+	}
+
+	if config.MTU > 0 {
+		err = exec.Command("ip", "link", "set", "mtu", strconv.Itoa(config.MTU), "dev", b.iface).Run()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = exec.Command("ip", "link", "set", "up", "dev", b.iface).Run()
+	if err != nil {
+		return err
+	}
+
+	table := strconv.Itoa(config.Table)
+	err = exec.Command("wg", "set", b.iface, "fwmark", table).Run()
+	if err != nil {
+		return err
+	}
+
+	var allowedIPs []string
+	for _, peer := range device.Wireguard.GetPeers() {
+		networks, err := peerAllowedIPs(peer, config)
+		if err != nil {
+			return err
+		}
+		allowedIPs = append(allowedIPs, networks...)
+	}
+
+	if err := ipReplaceRoutes(b.iface, table, allowedIPs); err != nil {
+		return err
+	}
+
+	return addOpenWRTRules(table)
+}
+
+// openWRTRules returns the ip rules routing everything except the encrypted traffic marked with the table through the table,
+// while still honoring the more specific routes of the main table, in the same manner as wg-quick does.
+// The first element of every rule is the address family flag.
+func openWRTRules(table string) [][]string {
+	var rules [][]string
+	for _, family := range []string{"-4", "-6"} {
+		rules = append(rules,
+			[]string{family, "not", "fwmark", table, "table", table, "priority", "32764"},
+			[]string{family, "table", "main", "suppress_prefixlength", "0", "priority", "32763"},
+		)
+	}
+	return rules
+}
+
+// addOpenWRTRules adds the openWRTRules of the table, keeping the ones already in place.
+func addOpenWRTRules(table string) error {
+	for _, rule := range openWRTRules(table) {
+		out, err := exec.Command("ip", append([]string{rule[0], "rule", "add"}, rule[1:]...)...).CombinedOutput()
+		if err != nil && !strings.Contains(string(out), "File exists") {
+			return fmt.Errorf("failed to add rule: %s: %w", strings.TrimSpace(string(out)), err)
+		}
+	}
+	return nil
+}
+
+func (b openWRTBackend) Down(config TunnelConfig) error {
+	if !b.isPersistent() {
+		for _, rule := range openWRTRules(strconv.Itoa(config.Table)) {
+			_ = exec.Command("ip", append([]string{rule[0], "rule", "del"}, rule[1:]...)...).Run()
+		}
+		return exec.Command("ip", "link", "del", "dev", b.iface).Run()
+	}
+
+	if err := exec.Command("uci", "-q", "delete", fmt.Sprintf("network.%s", b.iface)).Run(); err != nil {
+		return err
+	}
+	if err := exec.Command("uci", "-q", "delete", "network.wgserver").Run(); err != nil {
+		return err
+	}
+	return utils.Commit()
+}
+
+// Status calls a 'uci show' shell command for persistent connections and 'wg show <interface>' otherwise.
+func (b openWRTBackend) Status() (bool, error) {
+	if b.isPersistent() {
+		stdout, _ := exec.Command("uci", "show").CombinedOutput()
+		return strings.Contains(string(stdout), "wgserver"), nil
+	}
+
+	stdout, err := exec.Command("wg", "show", b.iface).CombinedOutput()
+	return err == nil && len(stdout) > 0, nil
+}
+
+func (b openWRTBackend) Stats() (TunnelStats, error) {
+	return wgShowStats(b.iface)
+}
+
+func (b openWRTBackend) UpdatePeers(config TunnelConfig, resolve bool) error {
+	return wgSetPeers(b.iface, config, resolve)
+}
+
+// UpdateRoutes sets the allowed IPs of the peers with 'wg set' shell commands and replaces the routes of the table of the tunnel with them.
+// The rules routing through the table are added again if they are gone, e.g. once the WAN interface was restarted.
+// The routes of the persistent connection are left to netifd.
+func (b openWRTBackend) UpdateRoutes(config TunnelConfig) error {
+	allowedIPs, err := wgSetAllowedIPs(b.iface, config)
+	if err != nil || b.isPersistent() {
+		return err
+	}
+
+	table := strconv.Itoa(config.Table)
+	if err := ipReplaceRoutes(b.iface, table, allowedIPs); err != nil {
+		return err
+	}
+
+	return addOpenWRTRules(table)
+}
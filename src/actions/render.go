@@ -0,0 +1,144 @@
+package actions
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"gopkg.in/yaml.v3"
+)
+
+// OutputFormats are the formats the locations can be rendered in, the first one is the default.
+var OutputFormats = []string{"table", "json", "csv", "yaml"}
+
+// LocationRecord is a structure representing a location in the machine-readable output formats.
+type LocationRecord struct {
+	ID          string `json:"id" yaml:"id"`
+	City        string `json:"city" yaml:"city"`
+	Country     string `json:"country" yaml:"country"`
+	CountryCode string `json:"country_code" yaml:"country_code"`
+	Premium     bool   `json:"premium" yaml:"premium"`
+	// Locked is true if the location requires a paid subscription the user does not have.
+	Locked bool `json:"locked" yaml:"locked"`
+	// LatencyMs is the round-trip time to the location in milliseconds, nil unless it was probed and answered.
+	LatencyMs *float64 `json:"latency_ms" yaml:"latency_ms"`
+}
+
+// NewLocationRecord is a function that flattens the location and the latency it was probed with into a LocationRecord.
+func NewLocationRecord(latency LocationLatency) LocationRecord {
+	location := latency.Location.Location
+	record := LocationRecord{
+		ID:          location.GetId(),
+		City:        location.GetName(),
+		Country:     location.Country.GetName(),
+		CountryCode: location.Country.GetId(),
+		Premium:     latency.Location.Premium,
+		Locked:      latency.Locked,
+	}
+
+	if latency.Reachable {
+		ms := math.Round(float64(latency.RTT)/float64(time.Millisecond)*10) / 10
+		record.LatencyMs = &ms
+	}
+
+	return record
+}
+
+// RenderLocations is a function that writes the locations in given format, one of OutputFormats.
+// The table has a latency column only if any of the locations was probed.
+func RenderLocations(out io.Writer, latencies []LocationLatency, format string) error {
+	records := make([]LocationRecord, 0, len(latencies))
+	probed := false
+	for _, latency := range latencies {
+		records = append(records, NewLocationRecord(latency))
+		probed = probed || latency.Locked || !latency.ProbedAt.IsZero()
+	}
+
+	switch format {
+	case "", "table":
+		renderLocationsTable(out, latencies, probed)
+		return nil
+	case "json":
+		data, err := json.MarshalIndent(records, "", "    ")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	case "yaml":
+		encoder := yaml.NewEncoder(out)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(records); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case "csv":
+		writer := csv.NewWriter(out)
+		if err := writer.Write([]string{"id", "city", "country", "country_code", "premium", "locked", "latency_ms"}); err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			latency := ""
+			if record.LatencyMs != nil {
+				latency = strconv.FormatFloat(*record.LatencyMs, 'f', -1, 64)
+			}
+
+			row := []string{record.ID, record.City, record.Country, record.CountryCode, strconv.FormatBool(record.Premium), strconv.FormatBool(record.Locked), latency}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+
+		writer.Flush()
+		return writer.Error()
+	}
+
+	return fmt.Errorf("unknown output format: %s, expected one of %v", format, OutputFormats)
+}
+
+// renderLocationsTable is a function to render the locations as a table, with the latencies if probed is set.
+func renderLocationsTable(out io.Writer, latencies []LocationLatency, probed bool) {
+	var data [][]string
+	for _, latency := range latencies {
+		premiumMark := ""
+		if latency.Location.Premium {
+			premiumMark = "*"
+		}
+
+		location := latency.Location.Location
+		row := []string{location.GetName(), location.Country.GetName(), location.GetId(), premiumMark}
+
+		if probed {
+			rtt := "timeout"
+			switch {
+			case latency.Locked:
+				rtt = "requires premium"
+			case latency.Reachable:
+				rtt = latency.RTT.Round(100 * time.Microsecond).String()
+			case latency.ProbedAt.IsZero():
+				rtt = "unknown"
+			}
+			row = append(row, rtt)
+		}
+
+		data = append(data, row)
+	}
+
+	header := []string{"City", "Country", "UUID", "Premium"}
+	if probed {
+		header = append(header, "Latency")
+	}
+
+	table := tablewriter.NewWriter(out)
+	table.SetHeader(header)
+	table.SetBorder(false)
+	table.AppendBulk(data)
+	table.Render()
+}
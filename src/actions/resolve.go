@@ -0,0 +1,304 @@
+package actions
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/utils"
+	"github.com/google/uuid"
+)
+
+// maxSuggestions limits the number of locations suggested when the input is ambiguous or matches nothing.
+const maxSuggestions = 5
+
+// LocationMatchError is returned when the input matches several locations equally well or none at all.
+// Suggestions hold the closest cities, as "City, Country", and countries.
+type LocationMatchError struct {
+	Input       string
+	Ambiguous   bool
+	Suggestions []string
+}
+
+func (e *LocationMatchError) Error() string {
+	message := fmt.Sprintf("no such location: %s", e.Input)
+	if e.Ambiguous {
+		message = fmt.Sprintf("ambiguous location: %s", e.Input)
+	}
+
+	switch len(e.Suggestions) {
+	case 0:
+		return message
+	case 1:
+		return fmt.Sprintf("%s, did you mean %s?", message, e.Suggestions[0])
+	}
+
+	last := len(e.Suggestions) - 1
+	return fmt.Sprintf("%s, did you mean %s or %s?", message, strings.Join(e.Suggestions[:last], "; "), e.Suggestions[last])
+}
+
+// placeMatch is a structure representing a city or a country the input can be matched against.
+type placeMatch struct {
+	// Label names the place in the suggestions.
+	Label string
+	// Names hold the folded names of the place, the ISO code for a country.
+	Names     []string
+	Locations []LocationWrapper
+	City      bool
+}
+
+// cityMatches is a function returning a placeMatch per location, named by it's city and alternative names.
+func cityMatches(locations []LocationWrapper) []placeMatch {
+	matches := make([]placeMatch, 0, len(locations))
+	for _, location := range locations {
+		names := []string{utils.FoldName(location.Location.GetName())}
+		for _, name := range location.Location.GetAlternativeNames() {
+			names = append(names, utils.FoldName(name))
+		}
+
+		matches = append(matches, placeMatch{
+			Label:     location.Location.GetName() + ", " + location.Location.Country.GetName(),
+			Names:     names,
+			Locations: []LocationWrapper{location},
+			City:      true,
+		})
+	}
+	return matches
+}
+
+// countryMatches is a function returning a placeMatch per country of the locations, named by it's name, alternative names
+// and ISO 3166-1 alpha-2 code, which the ID of a country is.
+func countryMatches(locations []LocationWrapper) []placeMatch {
+	var matches []placeMatch
+	index := make(map[string]int)
+
+	for _, location := range locations {
+		country := location.Location.Country
+		if i, ok := index[country.GetId()]; ok {
+			matches[i].Locations = append(matches[i].Locations, location)
+			continue
+		}
+
+		names := []string{utils.FoldName(country.GetName()), utils.FoldName(country.GetId())}
+		for _, name := range country.GetAlternativeNames() {
+			names = append(names, utils.FoldName(name))
+		}
+
+		index[country.GetId()] = len(matches)
+		matches = append(matches, placeMatch{Label: country.GetName(), Names: names, Locations: []LocationWrapper{location}})
+	}
+	return matches
+}
+
+// matchPlace is a function that finds the place matching the input best, trying in order an exact name, a unique prefix
+// of a name and finally the names within a few typos. The earlier stages win over the later ones, so a longer input
+// is needed only to pick one of the places sharing a prefix.
+// It returns a LocationMatchError with suggestions if several places match at the same stage or none matches at all.
+func matchPlace(places []placeMatch, input string) (placeMatch, error) {
+	folded := utils.FoldName(input)
+	if len(folded) == 0 {
+		return placeMatch{}, &LocationMatchError{Input: input}
+	}
+
+	stages := []func(name string) bool{
+		func(name string) bool { return name == folded },
+		func(name string) bool { return strings.HasPrefix(name, folded) },
+	}
+
+	for _, matches := range stages {
+		var found []placeMatch
+		for _, place := range places {
+			for _, name := range place.Names {
+				if matches(name) {
+					found = append(found, place)
+					break
+				}
+			}
+		}
+
+		found = preferCities(found)
+		switch len(found) {
+		case 0:
+			continue
+		case 1:
+			return found[0], nil
+		}
+		return placeMatch{}, &LocationMatchError{Input: input, Ambiguous: true, Suggestions: placeLabels(found)}
+	}
+
+	// A typo per three characters is tolerated, so the short names and ISO codes are not matched by accident.
+	tolerance := len([]rune(folded)) / 3
+	distances := make(map[string]int)
+	var nearby []placeMatch
+
+	for _, place := range places {
+		distance := -1
+		for _, name := range place.Names {
+			if d := utils.Levenshtein(folded, name); distance < 0 || d < distance {
+				distance = d
+			}
+		}
+
+		// Twice the tolerance is close enough to suggest the place without picking it.
+		if distance >= 0 && distance <= 2*tolerance {
+			distances[place.Label] = distance
+			nearby = append(nearby, place)
+		}
+	}
+
+	sort.SliceStable(nearby, func(i, j int) bool {
+		return distances[nearby[i].Label] < distances[nearby[j].Label]
+	})
+
+	if len(nearby) > 0 && distances[nearby[0].Label] <= tolerance {
+		best := []placeMatch{nearby[0]}
+		for _, place := range nearby[1:] {
+			if distances[place.Label] == distances[best[0].Label] {
+				best = append(best, place)
+			}
+		}
+
+		best = preferCities(best)
+		if len(best) == 1 {
+			return best[0], nil
+		}
+		return placeMatch{}, &LocationMatchError{Input: input, Ambiguous: true, Suggestions: placeLabels(best)}
+	}
+
+	return placeMatch{}, &LocationMatchError{Input: input, Suggestions: placeLabels(nearby)}
+}
+
+// preferCities is a function that drops the countries of the matched cities from the matched places,
+// so a city sharing it's name with it's country, or the only city of a country, is not reported as ambiguous.
+func preferCities(places []placeMatch) []placeMatch {
+	cities := make(map[string]bool)
+	for _, place := range places {
+		if place.City {
+			cities[place.Locations[0].Location.GetId()] = true
+		}
+	}
+
+	var preferred []placeMatch
+	for _, place := range places {
+		covered := false
+		if !place.City {
+			for _, location := range place.Locations {
+				covered = covered || cities[location.Location.GetId()]
+			}
+		}
+
+		if !covered {
+			preferred = append(preferred, place)
+		}
+	}
+	return preferred
+}
+
+// placeLabels is a function returning up to maxSuggestions labels of the places.
+func placeLabels(places []placeMatch) []string {
+	var labels []string
+	for _, place := range places {
+		if len(labels) == maxSuggestions {
+			break
+		}
+		labels = append(labels, place.Label)
+	}
+	return labels
+}
+
+// MatchLocations is a function that resolves the input into the locations it denotes: the location with the UUID,
+// the location of a city, the locations of a country given by name or ISO code, or either of them given by a unique prefix
+// or with a typo. A city within a country is given as "City, Country" to tell apart the cities with the same name.
+// It returns a LocationMatchError with suggestions if the input is ambiguous or matches nothing.
+func MatchLocations(locations []LocationWrapper, input string) ([]LocationWrapper, error) {
+	if id, err := uuid.Parse(input); err == nil {
+		for _, location := range locations {
+			if strings.EqualFold(location.Location.GetId(), id.String()) {
+				return []LocationWrapper{location}, nil
+			}
+		}
+		return nil, &LocationMatchError{Input: input}
+	}
+
+	if city, country, ok := strings.Cut(input, ","); ok {
+		place, err := matchPlace(countryMatches(locations), country)
+		if err != nil {
+			return nil, err
+		}
+
+		place, err = matchPlace(cityMatches(place.Locations), city)
+		if err != nil {
+			return nil, err
+		}
+		return place.Locations, nil
+	}
+
+	place, err := matchPlace(append(cityMatches(locations), countryMatches(locations)...), input)
+	if err != nil {
+		return nil, err
+	}
+	return place.Locations, nil
+}
+
+// MatchCountry is a function that resolves the input into the locations of the country given by name, ISO code,
+// a unique prefix or with a typo. It returns a LocationMatchError with suggestions if the input is ambiguous or matches nothing.
+func MatchCountry(locations []LocationWrapper, input string) ([]LocationWrapper, error) {
+	place, err := matchPlace(countryMatches(locations), input)
+	if err != nil {
+		return nil, err
+	}
+	return place.Locations, nil
+}
+
+// resolveLocation is a function that resolves the input into a single location with MatchLocations.
+// When the input denotes a country, the best location there is picked: one the billing feature of the user allows to use,
+// with the lowest latency it was last probed with, or the first one by name not known to be unreachable if none answered.
+func resolveLocation(userID auth.ProfileID, locations []LocationWrapper, input string, b forestvpn_api.BillingFeature) (LocationWrapper, error) {
+	matched, err := MatchLocations(locations, input)
+	if err != nil {
+		return LocationWrapper{}, err
+	}
+
+	if len(matched) == 1 {
+		return matched[0], nil
+	}
+
+	var allowed []LocationWrapper
+	for _, location := range matched {
+		if locationAllowed(b, location) {
+			allowed = append(allowed, location)
+		}
+	}
+
+	// None of the locations is allowed, so the first one is picked to report the subscription it requires.
+	if len(allowed) == 0 {
+		return matched[0], nil
+	}
+
+	cache := auth.LoadLatencyCache(userID)
+	best, bestRank := allowed[0], latencyRank(cache, allowed[0])
+
+	for _, location := range allowed[1:] {
+		rank := latencyRank(cache, location)
+		if rank < bestRank || rank == 0 && bestRank == 0 && cache.Latencies[location.Location.GetId()].RTT < cache.Latencies[best.Location.GetId()].RTT {
+			best, bestRank = location, rank
+		}
+	}
+
+	return best, nil
+}
+
+// latencyRank is a function ranking the location by the latency it was last probed with:
+// 0 if it answered, 1 if it was not probed yet and 2 if it was unreachable.
+func latencyRank(cache auth.LatencyCache, location LocationWrapper) int {
+	latency, ok := cache.Latencies[location.Location.GetId()]
+	switch {
+	case !ok:
+		return 1
+	case !latency.Reachable:
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,117 @@
+package actions_test
+
+import (
+	"reflect"
+	"testing"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+	"github.com/forestvpn/cli/actions"
+)
+
+func testLocation(id string, city string, countryID string, country string) actions.LocationWrapper {
+	return actions.LocationWrapper{Location: forestvpn_api.Location{
+		Id:      id,
+		Name:    city,
+		Country: forestvpn_api.Country{Id: countryID, Name: country},
+	}}
+}
+
+var testLocations = []actions.LocationWrapper{
+	testLocation("7d0e3a4c-0001-4b8a-9c1e-000000000001", "Frankfurt", "DE", "Germany"),
+	testLocation("7d0e3a4c-0001-4b8a-9c1e-000000000002", "Berlin", "DE", "Germany"),
+	testLocation("7d0e3a4c-0001-4b8a-9c1e-000000000003", "Paris", "FR", "France"),
+	testLocation("7d0e3a4c-0001-4b8a-9c1e-000000000004", "Valencia", "ES", "Spain"),
+	testLocation("7d0e3a4c-0001-4b8a-9c1e-000000000005", "Valencia", "VE", "Venezuela"),
+	testLocation("7d0e3a4c-0001-4b8a-9c1e-000000000006", "Singapore", "SG", "Singapore"),
+}
+
+func TestMatchLocations(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []string
+	}{
+		// UUID
+		{"7d0e3a4c-0001-4b8a-9c1e-000000000003", []string{"Paris"}},
+		{"7D0E3A4C-0001-4B8A-9C1E-000000000003", []string{"Paris"}},
+		// an exact name, case insensitive
+		{"frankfurt", []string{"Frankfurt"}},
+		{"Germany", []string{"Frankfurt", "Berlin"}},
+		// an exact ISO code wins over the prefix of a city
+		{"fr", []string{"Paris"}},
+		{"de", []string{"Frankfurt", "Berlin"}},
+		// a unique prefix
+		{"ber", []string{"Berlin"}},
+		{"venez", []string{"Valencia"}},
+		// a typo per three characters
+		{"frankfrut", []string{"Frankfurt"}},
+		{"berlni", []string{"Berlin"}},
+		// "City, Country" tells apart the cities with the same name
+		{"Valencia, Spain", []string{"Valencia"}},
+		{"valencia, VE", []string{"Valencia"}},
+		// a city named like it's country is preferred over the country
+		{"Singapore", []string{"Singapore"}},
+	}
+
+	for _, c := range cases {
+		matched, err := actions.MatchLocations(testLocations, c.input)
+		if err != nil {
+			t.Errorf("%q: %s", c.input, err)
+			continue
+		}
+
+		var actual []string
+		for _, location := range matched {
+			actual = append(actual, location.Location.GetName())
+		}
+
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("%q: expected %v, got %v", c.input, c.expected, actual)
+		}
+	}
+
+	countries := map[string]string{"Valencia, Spain": "ES", "valencia, VE": "VE"}
+	for input, expected := range countries {
+		matched, err := actions.MatchLocations(testLocations, input)
+		if err != nil {
+			t.Errorf("%q: %s", input, err)
+			continue
+		}
+
+		if actual := matched[0].Location.Country.GetId(); actual != expected {
+			t.Errorf("%q: expected a location in %s, got %s", input, expected, actual)
+		}
+	}
+}
+
+func TestMatchLocationsError(t *testing.T) {
+	cases := map[string]string{
+		// an unknown UUID
+		"7d0e3a4c-0001-4b8a-9c1e-000000000009": "no such location: 7d0e3a4c-0001-4b8a-9c1e-000000000009",
+		// the prefix of a city and a country
+		"fra": "ambiguous location: fra, did you mean Frankfurt, Germany or France?",
+		// the cities with the same name
+		"Valencia": "ambiguous location: Valencia, did you mean Valencia, Spain or Valencia, Venezuela?",
+		// the ISO codes are not matched with a typo
+		"dx": "no such location: dx",
+		// too many typos to pick a place, but close enough to suggest it
+		"prais":        "no such location: prais, did you mean Paris, France?",
+		"Paris, Spain": "no such location: Paris",
+		"xyzzy":        "no such location: xyzzy",
+	}
+
+	for input, expected := range cases {
+		_, err := actions.MatchLocations(testLocations, input)
+		if err == nil {
+			t.Errorf("%q: expected an error", input)
+			continue
+		}
+
+		if _, ok := err.(*actions.LocationMatchError); !ok {
+			t.Errorf("%q: expected a LocationMatchError, got %T", input, err)
+		}
+
+		if err.Error() != expected {
+			t.Errorf("%q: expected %q, got %q", input, expected, err.Error())
+		}
+	}
+}
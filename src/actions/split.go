@@ -0,0 +1,129 @@
+package actions
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/utils"
+)
+
+// SplitTunnel is a structure holding the split tunneling lists to save for the profile. A nil list keeps the saved one as is.
+type SplitTunnel struct {
+	// Routes are the networks routed through the tunnel in addition to the allowed IPs of the peer.
+	Routes *[]string
+	// Exclude are the networks kept outside of the tunnel.
+	Exclude *[]string
+	// Domains are the domain rules whose addresses are routed through the tunnel.
+	Domains *[]string
+	// BypassDomains are the domain rules whose addresses are kept outside of the tunnel.
+	BypassDomains *[]string
+	// DomainsOnly routes only the Routes and the Domains through the tunnel instead of the allowed IPs of the peer.
+	DomainsOnly *bool
+	// IPv6 is what to do with the IPv6 traffic when the location offers no IPv6 default route, e.g. auth.IPv6Block.
+	IPv6 *string
+}
+
+// IsSet reports whether any of the lists is given.
+func (s SplitTunnel) IsSet() bool {
+	return s.Routes != nil || s.Exclude != nil || s.Domains != nil || s.BypassDomains != nil || s.DomainsOnly != nil || s.IPv6 != nil
+}
+
+// SetSplitTunnel is a function that saves the networks and the domain rules to route through the tunnels of the profile or to exclude from them.
+// The domain rules are resolved and the Wireguard configuration file of the tunnel with given name is rewritten with the new allowed IPs.
+func SetSplitTunnel(userID auth.ProfileID, name string, split SplitTunnel) error {
+	config, err := auth.LoadConfig(userID)
+	if err != nil {
+		return err
+	}
+
+	if split.Routes != nil {
+		config.Routes = nonEmpty(*split.Routes)
+	}
+
+	if split.Exclude != nil {
+		config.Exclude = nonEmpty(*split.Exclude)
+	}
+
+	if _, err := utils.AllowedIPs(nil, config.Routes, config.Exclude); err != nil {
+		return err
+	}
+
+	if split.Domains != nil {
+		if config.Domains, err = normalizeDomains(*split.Domains); err != nil {
+			return err
+		}
+	}
+
+	if split.BypassDomains != nil {
+		if config.BypassDomains, err = normalizeDomains(*split.BypassDomains); err != nil {
+			return err
+		}
+	}
+
+	if split.DomainsOnly != nil {
+		config.DomainsOnly = *split.DomainsOnly
+	}
+
+	if config.DomainsOnly && len(config.Routes) == 0 && len(config.Domains) == 0 {
+		return errors.New("domains-only mode requires a domain rule or a route to send through the tunnel")
+	}
+
+	if split.IPv6 != nil {
+		if err := validIPv6(*split.IPv6); err != nil {
+			return err
+		}
+		config.IPv6 = *split.IPv6
+	}
+
+	if err := auth.SaveConfig(userID, config); err != nil {
+		return err
+	}
+
+	// The rules failing to resolve are reported once the configuration file is written with the rest of them.
+	_, resolveErr := ResolveDomains(userID)
+	if err := writeTunnelConfig(userID, name); err != nil {
+		return err
+	}
+
+	return resolveErr
+}
+
+// writeTunnelConfig is a function that rewrites the Wireguard configuration file of the tunnel with given name, so it picks up the profile configuration.
+// UCI is configured on OpenWRT instead.
+func writeTunnelConfig(userID auth.ProfileID, name string) error {
+	if utils.IsOpenWRT() {
+		return nil
+	}
+
+	device, err := auth.LoadTunnelDevice(userID, name)
+	if err != nil {
+		return err
+	}
+
+	return auth.CreateWireguardConfigurationFile(device, userID, name)
+}
+
+// nonEmpty returns the trimmed networks without the empty ones, so an empty flag value clears the list.
+func nonEmpty(networks []string) []string {
+	var result []string
+	for _, network := range networks {
+		if network = strings.TrimSpace(network); len(network) > 0 {
+			result = append(result, network)
+		}
+	}
+	return result
+}
+
+// normalizeDomains validates the non-empty domain rules and returns them normalized.
+func normalizeDomains(rules []string) ([]string, error) {
+	var result []string
+	for _, rule := range nonEmpty(rules) {
+		rule, err := auth.NormalizeDomain(rule)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, rule)
+	}
+	return result, nil
+}
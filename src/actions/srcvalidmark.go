@@ -0,0 +1,57 @@
+package actions
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/forestvpn/cli/auth"
+)
+
+// srcValidMarkPath is the sysctl making the reverse path filter take the firewall mark of the replies into account.
+const srcValidMarkPath = "/proc/sys/net/ipv4/conf/all/src_valid_mark"
+
+// srcValidMarkBackup is the file holding the value of the sysctl before enableSrcValidMark turned it on.
+var srcValidMarkBackup = filepath.Join(auth.AppDir, "src_valid_mark")
+
+// enableSrcValidMark is a function that turns the src_valid_mark sysctl on, so the replies marked from the connection pass the reverse path filter.
+// The original value is recorded the first time, so restoreSrcValidMark is able to put it back.
+func enableSrcValidMark() error {
+	current, err := os.ReadFile(srcValidMarkPath)
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(string(current)) == "1" {
+		return nil
+	}
+
+	if _, err := os.Stat(srcValidMarkBackup); os.IsNotExist(err) {
+		if err := os.MkdirAll(auth.AppDir, 0755); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(srcValidMarkBackup, current, 0644); err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(srcValidMarkPath, []byte("1"), 0644)
+}
+
+// restoreSrcValidMark is a function that puts back the value of the src_valid_mark sysctl recorded by enableSrcValidMark, if any.
+// It is called once neither the domain sets nor the commands run by Exec mark the traffic anymore.
+func restoreSrcValidMark() error {
+	original, err := os.ReadFile(srcValidMarkBackup)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(srcValidMarkPath, original, 0644); err != nil {
+		return err
+	}
+
+	return os.Remove(srcValidMarkBackup)
+}
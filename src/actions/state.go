@@ -3,39 +3,98 @@
 package actions
 
 import (
+	"errors"
 	"fmt"
-	"os/exec"
-	"strings"
+	"net"
+	"time"
 
+	forestvpn_api "github.com/forestvpn/api-client-go"
 	"github.com/forestvpn/cli/auth"
-	"github.com/forestvpn/cli/utils"
 )
 
 // State is a structure representing Wireguard connection state.
 type State struct {
 	status             bool
 	WiregaurdInterface string
+	// Backend is a name of the registered TunnelBackend used to control the connection.
+	Backend string
+	// Tunnel is a name of the tunnel of the profile, empty for the default tunnel.
+	Tunnel string
+	// Table is the routing table of the tunnel routes.
+	Table int
+}
+
+// UpOptions is a structure holding the options of establishing a Wireguard connection.
+type UpOptions struct {
+	// Persist asks the backend to keep the connection through reboots if it is able to.
+	Persist bool
+	// KillSwitch blocks all the traffic outside of the tunnel until the connection is set down.
+	KillSwitch bool
+	// AllowLAN lets the traffic to the local networks through the kill switch.
+	AllowLAN bool
+}
+
+// ConnectionStatus is a structure describing the connection of the interface.
+type ConnectionStatus struct {
+	Connected bool   `json:"connected"`
+	Interface string `json:"interface"`
+	Backend   string `json:"backend"`
+	// Location is the default location of the device.
+	Location forestvpn_api.Location `json:"location"`
+	// Via is the entry location of a multi-hop connection of the default tunnel.
+	Via *forestvpn_api.Location `json:"via,omitempty"`
+	// IPv6 is how the IPv6 traffic of the default tunnel is handled, e.g. IPv6Blocked when the location offers no IPv6 default route.
+	IPv6 string `json:"ipv6,omitempty"`
+	// MTU is the MTU of the tunnel, pinned or discovered toward it's endpoint, unknown if left to the backend.
+	MTU int `json:"mtu,omitempty"`
+	// ConnectedSince is unknown if the connection was not established by fvpn.
+	ConnectedSince *time.Time   `json:"connected_since,omitempty"`
+	Stats          *TunnelStats `json:"stats,omitempty"`
+}
+
+// GetState is a factory function that returns the State of the tunnel with given name, the default tunnel if the name is empty,
+// controlled by the backend given by the flag, or by the backend from the profile configuration if the flag is empty.
+// The interface name and the routing table of the tunnel are taken from the profile configuration.
+func GetState(userID auth.ProfileID, name string, backendFlag string) (State, error) {
+	backend, err := resolveBackend(backendFlag, userID)
+	if err != nil {
+		return State{}, err
+	}
+
+	config, err := auth.LoadConfig(userID)
+	if err != nil {
+		return State{}, err
+	}
+
+	if len(name) > 0 && !auth.TunnelExists(userID, name) {
+		return State{}, fmt.Errorf("no such tunnel: %s, set it's location with 'fvpn location set --name %s' first", name, name)
+	}
+
+	iface := config.InterfaceName(name)
+	if _, err := NewBackend(backend, iface); err != nil {
+		return State{}, err
+	}
+
+	return State{WiregaurdInterface: iface, Backend: backend, Tunnel: name, Table: config.Table(name)}, nil
+}
+
+// backend is a method returning the TunnelBackend of the State for it's interface.
+func (s *State) backend() (TunnelBackend, error) {
+	return NewBackend(s.Backend, s.WiregaurdInterface)
 }
 
 // Deprecated: setStatus is used to set a status of Wireguard connection on the State structure.
-// It calls a 'wg show' shell command and analyzes it's output.
+// It asks the backend whether the interface is up.
 //
 // Using api.ApiClientWrapper.GetStatus instead
 func (s *State) setStatus() {
 	s.status = false
-	if utils.IsOpenWRT() {
-		stdout, _ := exec.Command("uci", "show").CombinedOutput()
-
-		if strings.Contains(string(stdout), "wgserver") {
-			s.status = true
-		}
-	} else {
-		stdout, _ := exec.Command("wg", "show").CombinedOutput()
-
-		if len(stdout) > 0 {
-			s.status = true
-		}
+	backend, err := s.backend()
+	if err != nil {
+		return
 	}
+
+	s.status, _ = backend.Status()
 }
 
 // GetStatus is a method to get the status of a Wireguard connection.
@@ -46,93 +105,477 @@ func (s *State) GetStatus() bool {
 	return s.status
 }
 
-// SetUp is a method used to establish a Wireguard connection.
-// It executes 'wg-quick' shell command.
-func (s *State) SetUp(user_id auth.ProfileID, persist bool) error {
-	var allowedIPs []string
-	path := auth.ProfilesDir + string(user_id) + auth.WireguardConfig
+// GetStats is a method to get the handshake and transfer stats of a Wireguard connection.
+func (s *State) GetStats() (TunnelStats, error) {
+	backend, err := s.backend()
+	if err != nil {
+		return TunnelStats{}, err
+	}
+
+	return backend.Stats()
+}
+
+// Describe is a method that reports the state of the connection along with the stats of the peer and the time it was established at.
+func (s *State) Describe(userID auth.ProfileID) (ConnectionStatus, error) {
+	status := ConnectionStatus{Connected: s.GetStatus(), Interface: s.WiregaurdInterface, Backend: s.Backend}
 
-	if utils.Os == "windows" {
-		return exec.Command("wireguard", "/installtunnelservice", path).Run()
-	} else if utils.IsOpenWRT() {
-		device, err := auth.LoadDevice(user_id)
+	device, err := auth.LoadTunnelDevice(userID, s.Tunnel)
+	if err != nil {
+		return status, err
+	}
+	status.Location = device.GetLocation()
+
+	if !status.Connected {
+		return status, nil
+	}
+
+	stats, err := s.GetStats()
+	if err != nil {
+		return status, err
+	}
+	status.Stats = &stats
+
+	if status.MTU, err = auth.LoadMTU(userID, s.Tunnel); err != nil {
+		return status, err
+	}
+
+	if len(s.Tunnel) == 0 {
+		profile, err := auth.LoadConfig(userID)
 		if err != nil {
-			return err
+			return status, err
 		}
+		status.IPv6 = ipv6Decision(device, profile)
 
-		IPs := device.GetIps()
-		if persist {
-			err = utils.Firewall(s.WiregaurdInterface)
+		if profile.MultiHop {
+			hop, err := auth.LoadTunnelDevice(userID, auth.HopTunnel)
 			if err != nil {
-				return err
+				return status, err
 			}
+			via := hop.GetLocation()
+			status.Via = &via
+		}
+	}
+
+	sessions, err := auth.LoadSessions(userID)
+	if err != nil {
+		return status, err
+	}
+
+	if session, ok := sessions[s.WiregaurdInterface]; ok {
+		status.ConnectedSince = &session.ConnectedSince
+	}
+
+	return status, nil
+}
+
+// tunnelConfig is a method that loads the data the backend needs to control the connection of the user.
+func (s *State) tunnelConfig(user_id auth.ProfileID, persist bool) (TunnelConfig, error) {
+	device, err := auth.LoadTunnelDevice(user_id, s.Tunnel)
+	if err != nil {
+		return TunnelConfig{}, err
+	}
+
+	profile, err := auth.LoadConfig(user_id)
+	if err != nil {
+		return TunnelConfig{}, err
+	}
+
+	mtu, err := auth.LoadMTU(user_id, s.Tunnel)
+	if err != nil {
+		return TunnelConfig{}, err
+	}
+
+	return TunnelConfig{
+		ConfigPath: auth.WireguardConfigPath(user_id, s.Tunnel, s.WiregaurdInterface),
+		Device:     device,
+		Persist:    persist,
+		Table:      s.Table,
+		Default:    len(s.Tunnel) == 0,
+		MTU:        mtu,
+		Profile:    profile,
+		Endpoints:  pinnedEndpoints(user_id, s.WiregaurdInterface),
+	}, nil
+}
+
+// endpoints is a method that returns the addresses the endpoints of the device of the tunnel resolve to,
+// or the ones they were pinned to on connecting while the kill switch is enabled.
+func (s *State) endpoints(user_id auth.ProfileID) (map[string]*net.UDPAddr, error) {
+	if pinned := pinnedEndpoints(user_id, s.WiregaurdInterface); len(pinned) > 0 {
+		return pinned, nil
+	}
+
+	device, err := auth.LoadTunnelDevice(user_id, s.Tunnel)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolveEndpoints(device)
+}
+
+// connectedEndpoints is a method that returns the addresses the endpoints of the device of the tunnel resolved to on connecting,
+// or resolves them if the connection did not record them.
+func (s *State) connectedEndpoints(user_id auth.ProfileID) (map[string]*net.UDPAddr, error) {
+	if recorded := sessionEndpoints(user_id, s.WiregaurdInterface); len(recorded) > 0 {
+		return recorded, nil
+	}
+
+	device, err := auth.LoadTunnelDevice(user_id, s.Tunnel)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolveEndpoints(device)
+}
+
+// SetUp is a method used to establish a Wireguard connection with the backend of the State,
+// along with everything the options and the profile configuration ask for. What was set up is undone if a later step fails.
+func (s *State) SetUp(user_id auth.ProfileID, options UpOptions) (err error) {
+	s.recordLostSession(user_id)
+	defer func() {
+		if err != nil {
+			s.recordHistory(user_id, auth.HistoryEntry{Event: auth.HistoryFailed, Reason: err.Error()})
+		}
+	}()
+
+	backend, err := s.backend()
+	if err != nil {
+		return err
+	}
+
+	if options.KillSwitch && len(s.Tunnel) > 0 {
+		return fmt.Errorf("kill switch is only supported by the default tunnel")
+	}
+
+	if err := RunHooks(PreUp, s, user_id); err != nil {
+		return err
+	}
+
+	// The domain rules failing to resolve keep their previous addresses, which does not fail the connection.
+	if changed, _ := ResolveDomains(user_id); changed {
+		if err := writeTunnelConfig(user_id, s.Tunnel); err != nil {
+			return err
+		}
+	}
+
+	// The endpoints are resolved once, before the kill switch drops the queries to the resolver, and pinned for the connection.
+	device, err := auth.LoadTunnelDevice(user_id, s.Tunnel)
+	if err != nil {
+		return err
+	}
 
-			peer := device.Wireguard.GetPeers()[0]
-			endpoint := strings.Split(peer.GetEndpoint(), ":")
-			activeSShClient := utils.GetActiveSshClient()
+	endpoints, err := resolveEndpoints(device)
+	if err != nil {
+		return err
+	}
+
+	var hop *State
+	if len(s.Tunnel) == 0 {
+		if hop, err = s.setUpHop(user_id, options, endpoints); err != nil {
+			return err
+		}
+	}
+
+	if hop != nil {
+		defer func() {
 			if err != nil {
-				return err
+				_ = hop.SetDown(user_id)
 			}
+		}()
+	}
 
-			if len(activeSShClient) > 0 {
-				allowedIPs, err = utils.ExcludeDisallowedIps(peer.GetAllowedIps(), activeSShClient)
-				if err != nil {
-					return err
-				}
-			}
+	// Failing to discover the MTU keeps the one discovered before, or the default one of the backend.
+	_, _ = DiscoverMTU(user_id, s.Tunnel)
 
-			return utils.Network(s.WiregaurdInterface, device.Wireguard.GetPrivKey(), IPs, peer.GetPubKey(), peer.GetPsKey(), endpoint[0], endpoint[1], allowedIPs)
-		} else {
-			err := exec.Command("ip", "link", "add", "dev", s.WiregaurdInterface, "type", "wireguard").Run()
-			if err != nil {
+	config, err := s.tunnelConfig(user_id, options.Persist)
+	if err != nil {
+		return err
+	}
+	config.Endpoints = endpoints
+
+	if config.Default && config.Profile.UsesDomainSets() && s.Backend != NetlinkBackend && s.Backend != OpenWRTBackend {
+		return fmt.Errorf("wildcard domain rules are not supported by the %s backend", s.Backend)
+	}
+
+	if options.KillSwitch && config.Profile.DomainsOnly {
+		return fmt.Errorf("kill switch drops the traffic the domains-only mode keeps outside of the tunnel")
+	}
+
+	if options.KillSwitch {
+		// The encrypted traffic of a multi-hop connection leaves through the HopTunnel toward the endpoints of it's device.
+		ifaces, allowed := []string{s.WiregaurdInterface}, endpoints
+		if hop != nil {
+			ifaces = append(ifaces, hop.WiregaurdInterface)
+			if allowed, err = hop.connectedEndpoints(user_id); err != nil {
 				return err
 			}
+		}
+
+		if err := EnableKillSwitch(ifaces, endpointAddresses(allowed), options.AllowLAN); err != nil {
+			return err
+		}
 
-			err = exec.Command("ip", "address", "add", "dev", s.WiregaurdInterface, IPs[1]).Run()
+		defer func() {
 			if err != nil {
-				return err
+				_ = DisableKillSwitch()
 			}
+		}()
+	}
 
-			err = exec.Command("ip", "-6", "address", "add", "dev", s.WiregaurdInterface, IPs[2]).Run()
+	if options.Persist && persistsWithSystemd(s.Backend) {
+		if err := s.enablePersistence(config.ConfigPath, options); err != nil {
+			return err
+		}
+
+		defer func() {
 			if err != nil {
-				return err
+				_ = DisablePersistence(s.WiregaurdInterface)
 			}
+		}()
+	}
+
+	if err := backend.Up(config); err != nil {
+		return err
+	}
 
-			err = exec.Command("wg", "setconf", s.WiregaurdInterface, path).Run()
+	defer func() {
+		if err != nil {
+			_ = backend.Down(config)
+		}
+	}()
+
+	if err := s.applyDNS(config); err != nil {
+		return err
+	}
+
+	if config.Default && managesDNS(s.Backend) {
+		defer func() {
 			if err != nil {
-				return err
+				_ = RevertDNS(s.WiregaurdInterface)
 			}
+		}()
+	}
+
+	if blocksIPv6(config) {
+		if err := BlockIPv6(s.WiregaurdInterface, endpointAddresses(endpoints)); err != nil {
+			return err
+		}
+	}
+
+	if config.Default {
+		if err := EnableDomainSets(s.WiregaurdInterface, config.Profile); err != nil {
+			return err
+		}
 
-			err = exec.Command("ip", "link", "set", "up", "dev", s.WiregaurdInterface).Run()
+		defer func() {
 			if err != nil {
-				return err
+				_ = DisableDomainSets()
 			}
+		}()
+	}
 
-			return exec.Command("ip", "route", "add", "default", "dev", s.WiregaurdInterface).Run()
-		}
-	} else {
-		return exec.Command("wg-quick", "up", path).Run()
+	// The session only serves the status, failing to record it does not fail the connection.
+	session := auth.Session{Backend: s.Backend, ConnectedSince: time.Now(), Endpoints: make(map[string]string)}
+	for endpoint, address := range endpoints {
+		session.Endpoints[endpoint] = address.String()
 	}
+	_ = auth.SaveSession(user_id, s.WiregaurdInterface, session)
+	s.recordHistory(user_id, auth.HistoryEntry{Event: auth.HistoryUp})
+
+	s.runHooksAfter(PostUp, user_id)
+	return nil
 }
 
-// SetDown is used to terminate a Wireguard connection.
-// It executes 'wg-quick' shell command.
+// SetDown is used to terminate a Wireguard connection with the backend of the State and to undo what SetUp set up along with it.
 func (s *State) SetDown(user_id auth.ProfileID) error {
-	configPath := auth.ProfilesDir + string(user_id) + auth.WireguardConfig
-	var command *exec.Cmd
-	switch {
-	case utils.Os == "windows":
-		command = exec.Command("wireguard", "/uninstalltunnelservice", s.WiregaurdInterface)
-	case utils.IsOpenWRT():
-		if err := exec.Command("uci", "-q", "delete", fmt.Sprintf("network.%s", s.WiregaurdInterface)).Run(); err != nil {
+	backend, err := s.backend()
+	if err != nil {
+		return err
+	}
+
+	config, err := s.tunnelConfig(user_id, false)
+	if err != nil {
+		return err
+	}
+
+	s.runHooksAfter(PreDown, user_id)
+
+	// The stats and the session are gone along with the interface, so they are read for the journal beforehand.
+	entry := auth.HistoryEntry{Event: auth.HistoryDown, Reason: HistoryDisconnected}
+	if stats, err := backend.Stats(); err == nil {
+		entry.RxBytes, entry.TxBytes = stats.RxBytes, stats.TxBytes
+	}
+	if sessions, err := auth.LoadSessions(user_id); err == nil {
+		if session, ok := sessions[s.WiregaurdInterface]; ok {
+			entry.Duration = int64(time.Since(session.ConnectedSince).Seconds())
+		}
+	}
+
+	// Every step is run even if an earlier one fails, so a failure does not leave the kill switch or the DNS of the host behind.
+	var errs []error
+	downErr := backend.Down(config)
+	errs = append(errs, downErr)
+
+	if config.Default && managesDNS(s.Backend) {
+		errs = append(errs, RevertDNS(s.WiregaurdInterface))
+	}
+
+	if config.Default {
+		errs = append(errs, UnblockIPv6(), DisableDomainSets())
+	}
+
+	errs = append(errs, DisablePersistence(s.WiregaurdInterface))
+
+	if downErr == nil {
+		_ = auth.RemoveSession(user_id, s.WiregaurdInterface)
+		s.recordHistory(user_id, entry)
+	}
+
+	if len(s.Tunnel) == 0 {
+		errs = append(errs, s.setDownHop(user_id), DisableKillSwitch())
+	}
+
+	s.runHooksAfter(PostDown, user_id)
+	return errors.Join(errs...)
+}
+
+// runHooksAfter is a method that runs the hooks of the stage which are unable to abort the change of the connection,
+// so their failure is only logged.
+func (s *State) runHooksAfter(stage string, user_id auth.ProfileID) {
+	if err := RunHooks(stage, s, user_id); err != nil {
+		auth.NewSimpleLogger().WithField("interface", s.WiregaurdInterface).WithError(err).Warnf("%+v", err)
+	}
+}
+
+// UpdatePeers is a method that applies the peers of the device to the interface again, if the backend is able to.
+// If resolve is true, the endpoints of the peers are resolved anew.
+func (s *State) UpdatePeers(user_id auth.ProfileID, resolve bool) error {
+	backend, err := s.backend()
+	if err != nil {
+		return err
+	}
+
+	updater, ok := backend.(PeerUpdater)
+	if !ok {
+		return fmt.Errorf("backend %s is unable to update the peers", s.Backend)
+	}
+
+	config, err := s.tunnelConfig(user_id, false)
+	if err != nil {
+		return err
+	}
+
+	return updater.UpdatePeers(config, resolve)
+}
+
+// UpdateRoutes is a method that applies the allowed IPs of the peers to the interface along with the routes of the tunnel, if the backend is able to,
+// e.g. once the domain rules resolved to new addresses. Unlike UpdatePeers, the sessions of the peers are kept.
+func (s *State) UpdateRoutes(user_id auth.ProfileID) error {
+	defer changingRoutes(s.Table)()
+
+	backend, err := s.backend()
+	if err != nil {
+		return err
+	}
+
+	updater, ok := backend.(RouteUpdater)
+	if !ok {
+		return fmt.Errorf("backend %s is unable to update the routes", s.Backend)
+	}
+
+	config, err := s.tunnelConfig(user_id, false)
+	if err != nil {
+		return err
+	}
+
+	if err := updater.UpdateRoutes(config); err != nil {
+		return err
+	}
+
+	// The tunnel sets of the DomainsOnly mode start with the addresses of the domain rules.
+	if config.Default {
+		return EnableDomainSets(s.WiregaurdInterface, config.Profile)
+	}
+
+	return nil
+}
+
+// Reconnect is a method that re-establishes the connection once the network of the host changed, e.g. after roaming or resuming from suspend.
+// The routes of the tunnel are applied again, if the backend is able to, and the peers start a new handshake over the new route
+// with their endpoints resolved anew. The HopTunnel of a multi-hop connection is reconnected first.
+func (s *State) Reconnect(user_id auth.ProfileID) error {
+	defer changingRoutes(s.Table)()
+
+	if len(s.Tunnel) == 0 {
+		if err := s.reconnectHop(user_id); err != nil {
+			return err
+		}
+	}
+
+	backend, err := s.backend()
+	if err != nil {
+		return err
+	}
+
+	config, err := s.tunnelConfig(user_id, false)
+	if err != nil {
+		return err
+	}
+
+	if updater, ok := backend.(RouteUpdater); ok {
+		if err := updater.UpdateRoutes(config); err != nil {
 			return err
 		}
-		if err := exec.Command("uci", "-q", "delete", "network.wgserver").Run(); err != nil {
+	}
+
+	updater, ok := backend.(PeerUpdater)
+	if !ok {
+		return fmt.Errorf("backend %s is unable to reconnect the peers", s.Backend)
+	}
+
+	return updater.UpdatePeers(config, true)
+}
+
+// Recreate is a method that sets the interface down and up again with the backend of the State, or only up if it is missing.
+// Unlike SetDown and SetUp, it leaves the kill switch, the DNS configuration and the IPv6 block in place, so no traffic leaks in the meantime.
+// The HopTunnel of a multi-hop connection is re-created first.
+func (s *State) Recreate(user_id auth.ProfileID, persist bool) error {
+	defer changingRoutes(s.Table)()
+
+	if len(s.Tunnel) == 0 {
+		if err := s.recreateHop(user_id); err != nil {
 			return err
 		}
-		return utils.Commit()
-	default:
-		command = exec.Command("wg-quick", "down", configPath)
 	}
-	return command.Run()
+
+	backend, err := s.backend()
+	if err != nil {
+		return err
+	}
+
+	config, err := s.tunnelConfig(user_id, persist)
+	if err != nil {
+		return err
+	}
+
+	if up, _ := backend.Status(); up {
+		if err := backend.Down(config); err != nil {
+			return err
+		}
+	}
+
+	if err := backend.Up(config); err != nil {
+		return err
+	}
+
+	return s.applyDNS(config)
+}
+
+// applyDNS is a method that points the system resolver at the DNS servers of the device of the default tunnel
+// with the dnsManager of the system, unless the backend applies them itself. SetDown reverts them with RevertDNS.
+// The DomainsOnly mode keeps the system resolver, since the DNS servers of the device are only reachable through the tunnel.
+func (s *State) applyDNS(config TunnelConfig) error {
+	if !config.Default || !managesDNS(s.Backend) || config.Profile.DomainsOnly {
+		return nil
+	}
+	return ApplyDNS(s.WiregaurdInterface, config.Device.GetDns())
 }
@@ -0,0 +1,196 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/forestvpn/cli/utils"
+)
+
+// SystemdUnitDir is the directory of the systemd units and drop-ins configured by the administrator.
+const SystemdUnitDir = "/etc/systemd/system/"
+
+// systemdDropIn is the name of the drop-in pointing the wg-quick@ unit of the interface at the configuration file of the profile.
+const systemdDropIn = "fvpn.conf"
+
+// ErrSystemdUnsupported is returned when a persistent connection is requested on a Linux host without systemd, or without wg-quick for the wg-quick backend.
+var ErrSystemdUnsupported = errors.New("persistent connections require systemd and wireguard-tools")
+
+// persistsWithSystemd reports whether the connections of the backend persist through reboots with the systemd units.
+// The OpenWRT backend persists them with UCI instead.
+func persistsWithSystemd(backend string) bool {
+	return utils.Os == "linux" && (backend == NetlinkBackend || backend == WgQuickBackend)
+}
+
+// systemdUnit returns the name of the wg-quick@ unit of the interface.
+func systemdUnit(iface string) string {
+	return fmt.Sprintf("wg-quick@%s.service", iface)
+}
+
+// systemdDropInPath returns the path of the drop-in of the wg-quick@ unit of the interface.
+func systemdDropInPath(iface string) string {
+	return filepath.Join(SystemdUnitDir, systemdUnit(iface)+".d", systemdDropIn)
+}
+
+// upUnit returns the name of the unit running 'fvpn state up' for the interface.
+func upUnit(iface string) string {
+	return fmt.Sprintf("fvpn-%s.service", iface)
+}
+
+// upUnitPath returns the path of the unit running 'fvpn state up' for the interface.
+func upUnitPath(iface string) string {
+	return filepath.Join(SystemdUnitDir, upUnit(iface))
+}
+
+// enablePersistence is a method that makes the connection of the State persist through reboots with the systemd units.
+// The configuration file of the wg-quick backend is brought up by wg-quick itself.
+// The other backends leave the DNS servers, the kill switch and the routes to fvpn, so their connection is brought up by 'fvpn state up'.
+func (s *State) enablePersistence(configPath string, options UpOptions) error {
+	if s.Backend == WgQuickBackend {
+		return EnablePersistence(s.WiregaurdInterface, configPath)
+	}
+	return enableUpUnit(s.WiregaurdInterface, s.Tunnel, s.Backend, options)
+}
+
+// enableUpUnit is a function that enables a unit running 'fvpn state up' with the backend and the options for the tunnel with given name on boot.
+// The unit is not started, since the connection is being established. It has no stop command, as 'fvpn state down' disables the unit.
+func enableUpUnit(iface string, tunnel string, backend string, options UpOptions) error {
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		return ErrSystemdUnsupported
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	args := []string{executable, "--backend", backend, "state", "up"}
+	if len(tunnel) > 0 {
+		args = append(args, "--name", tunnel)
+	}
+	if options.KillSwitch {
+		args = append(args, "--kill-switch")
+	}
+	if options.AllowLAN {
+		args = append(args, "--allow-lan")
+	}
+
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, strconv.Quote(arg))
+	}
+
+	var unit strings.Builder
+	fmt.Fprintf(&unit, "# Generated by fvpn, removed by 'fvpn state down'.\n")
+	fmt.Fprintf(&unit, "[Unit]\nDescription=ForestVPN connection on %s\nWants=network-online.target\nAfter=network-online.target\n", iface)
+	fmt.Fprintf(&unit, "[Service]\nType=oneshot\nRemainAfterExit=yes\n")
+	// The profiles are looked up in the home directory of the user persisting the connection.
+	fmt.Fprintf(&unit, "Environment=%s\n", strconv.Quote("HOME="+home))
+	fmt.Fprintf(&unit, "ExecStart=%s\n", strings.Join(quoted, " "))
+	fmt.Fprintf(&unit, "[Install]\nWantedBy=multi-user.target\n")
+
+	if err := os.WriteFile(upUnitPath(iface), []byte(unit.String()), 0644); err != nil {
+		return err
+	}
+
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+
+	return systemctl("enable", upUnit(iface))
+}
+
+// EnablePersistence is a function that enables the wg-quick@ unit of the interface with a drop-in bringing it up with the configuration file on boot.
+// The unit is not started, since the connection is established by the backend.
+// SetUp enables it for a persistent connection of the wg-quick backend, SetDown disables it.
+func EnablePersistence(iface string, configPath string) error {
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		return ErrSystemdUnsupported
+	}
+
+	wgQuick, err := exec.LookPath("wg-quick")
+	if err != nil {
+		return ErrSystemdUnsupported
+	}
+
+	var dropIn strings.Builder
+	fmt.Fprintf(&dropIn, "# Generated by fvpn, removed by 'fvpn state down'.\n[Service]\n")
+	fmt.Fprintf(&dropIn, "ExecStart=\nExecStart=%s up %s\n", wgQuick, configPath)
+	fmt.Fprintf(&dropIn, "ExecStop=\nExecStop=%s down %s\n", wgQuick, configPath)
+	// The reload of the unit expects the configuration file in /etc/wireguard.
+	fmt.Fprintf(&dropIn, "ExecReload=\n")
+
+	path := systemdDropInPath(iface)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, []byte(dropIn.String()), 0644); err != nil {
+		return err
+	}
+
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+
+	return systemctl("enable", systemdUnit(iface))
+}
+
+// DisablePersistence is a function that disables the wg-quick@ unit of the interface and removes it's drop-in,
+// or disables and removes the unit running 'fvpn state up' for it, if the connection is persistent.
+func DisablePersistence(iface string) error {
+	if !PersistenceEnabled(iface) {
+		return nil
+	}
+
+	if _, err := os.Stat(upUnitPath(iface)); err == nil {
+		if err := systemctl("disable", upUnit(iface)); err != nil {
+			return err
+		}
+
+		if err := os.Remove(upUnitPath(iface)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(systemdDropInPath(iface)); err == nil {
+		if err := systemctl("disable", systemdUnit(iface)); err != nil {
+			return err
+		}
+
+		if err := os.RemoveAll(filepath.Dir(systemdDropInPath(iface))); err != nil {
+			return err
+		}
+	}
+
+	return systemctl("daemon-reload")
+}
+
+// PersistenceEnabled is a function to check whether the wg-quick@ unit of the interface has the drop-in of fvpn,
+// or the unit running 'fvpn state up' for it is in place.
+func PersistenceEnabled(iface string) bool {
+	for _, path := range []string{systemdDropInPath(iface), upUnitPath(iface)} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// systemctl runs the systemctl shell command with the arguments.
+func systemctl(args ...string) error {
+	if out, err := exec.Command("systemctl", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to %s: %s: %w", strings.Join(args, " "), strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package actions
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/userspace"
+)
+
+// UserspaceState is a structure representing a running userspace tunnel and the local proxies serving it.
+type UserspaceState struct {
+	tunnel    *userspace.Tunnel
+	listeners []net.Listener
+	errs      chan error
+}
+
+// SetUpUserspace is a function that establishes a Wireguard connection inside of the process without root privileges
+// and starts the SOCKS5 and HTTP proxies on the given addresses. An empty address disables the proxy.
+func SetUpUserspace(user_id auth.ProfileID, socksAddress string, httpAddress string) (*UserspaceState, error) {
+	if len(socksAddress) == 0 && len(httpAddress) == 0 {
+		return nil, fmt.Errorf("either SOCKS5 or HTTP proxy address is required")
+	}
+
+	device, err := auth.LoadDevice(user_id)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnel, err := userspace.NewTunnel(device, userspace.DefaultMTU)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &UserspaceState{tunnel: tunnel, errs: make(chan error, 2)}
+
+	if len(socksAddress) > 0 {
+		listener, err := net.Listen("tcp", socksAddress)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.listeners = append(s.listeners, listener)
+		go func() { s.errs <- tunnel.ServeSOCKS5(listener) }()
+		fmt.Printf("SOCKS5 proxy is listening on %s\n", listener.Addr())
+	}
+
+	if len(httpAddress) > 0 {
+		listener, err := net.Listen("tcp", httpAddress)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.listeners = append(s.listeners, listener)
+		go func() { s.errs <- tunnel.ServeHTTPProxy(listener) }()
+		fmt.Printf("HTTP proxy is listening on %s\n", listener.Addr())
+	}
+
+	return s, nil
+}
+
+// Wait is a method that blocks until the process is interrupted or one of the proxies fails, then shuts the tunnel down.
+func (s *UserspaceState) Wait() error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var err error
+	select {
+	case <-signals:
+	case err = <-s.errs:
+	}
+
+	s.close()
+	return err
+}
+
+func (s *UserspaceState) close() {
+	for _, listener := range s.listeners {
+		_ = listener.Close()
+	}
+	s.tunnel.Close()
+}
@@ -0,0 +1,175 @@
+package actions
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/goauthlib/pkg/logger"
+)
+
+// DefaultWatchdogInterval is how often the Watchdog checks the tunnel by default.
+const DefaultWatchdogInterval = 30 * time.Second
+
+// maxRecreateBackoff limits the time the Watchdog waits before re-creating the interface again after re-creating it failed.
+const maxRecreateBackoff = 5 * time.Minute
+
+// DefaultHandshakeTimeout is the age of the latest handshake after which the tunnel is considered stalled by default.
+// Wireguard rekeys every 2 minutes and gives up on a session after 3 minutes.
+const DefaultHandshakeTimeout = 3 * time.Minute
+
+// Watchdog is a structure that monitors the latest handshake and the received bytes of the tunnel and repairs it once they go stale.
+// The repair escalates with every check the tunnel is still stalled on: the peers are applied again, then their endpoints are resolved anew,
+// and finally the interface is re-created. A missing interface, e.g. deleted by another program, is re-created right away.
+// Re-creating the interface is retried with an exponential backoff as long as it fails.
+type Watchdog struct {
+	State  State
+	UserID auth.ProfileID
+	// Persist is passed to the backend when the interface is re-created.
+	Persist          bool
+	Interval         time.Duration
+	HandshakeTimeout time.Duration
+	// Locker, if set, is held during every check, e.g. to not interfere with setting the connection down.
+	Locker sync.Locker
+	Logger logger.Logger
+
+	started time.Time
+	rx      int64
+	tx      int64
+	attempt int
+	// failures counts the failed attempts to re-create the interface in a row, which are retried once retryAt passes.
+	failures int
+	retryAt  time.Time
+}
+
+// NewWatchdog is a factory function that returns a Watchdog of the State with the default intervals logging through auth.SimpleLogger.
+func NewWatchdog(state State, userID auth.ProfileID, persist bool) *Watchdog {
+	return &Watchdog{
+		State:            state,
+		UserID:           userID,
+		Persist:          persist,
+		Interval:         DefaultWatchdogInterval,
+		HandshakeTimeout: DefaultHandshakeTimeout,
+		Logger:           auth.NewSimpleLogger(),
+	}
+}
+
+// Run is a method that checks the tunnel every Interval until the context is done.
+func (w *Watchdog) Run(ctx context.Context) {
+	w.started = time.Now()
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if w.Locker != nil {
+				w.Locker.Lock()
+			}
+			if ctx.Err() == nil {
+				w.check()
+			}
+			if w.Locker != nil {
+				w.Locker.Unlock()
+			}
+		}
+	}
+}
+
+// check is a method that reads the stats of the tunnel and makes the next repair attempt if it is stalled.
+func (w *Watchdog) check() {
+	log := w.Logger.WithField("interface", w.State.WiregaurdInterface)
+
+	stats, err := w.State.GetStats()
+	if err != nil && w.State.GetStatus() {
+		log.WithError(err).Warnf("failed to get the tunnel stats: %+v", err)
+		return
+	}
+
+	// The peers of a missing interface can not be applied again, so it is re-created right away.
+	missing := err != nil
+	if !missing && !w.stalled(stats) {
+		if w.attempt > 0 {
+			log.Infof("tunnel recovered after %d reconnect attempt(s)", w.attempt)
+			w.attempt = 0
+		}
+		return
+	}
+
+	if missing && w.attempt < 2 {
+		w.attempt = 2
+	}
+
+	w.attempt++
+	log = log.WithField("attempt", w.attempt)
+
+	switch w.attempt {
+	case 1:
+		log.Infof("tunnel is stalled, applying the peers again")
+		err = w.State.UpdatePeers(w.UserID, false)
+	case 2:
+		log.Infof("tunnel is still stalled, resolving the endpoints again")
+		err = w.State.UpdatePeers(w.UserID, true)
+	default:
+		err = w.recreate(log, missing)
+	}
+
+	if err != nil {
+		log.WithError(err).Errorf("reconnect attempt failed: %+v", err)
+	}
+}
+
+// recreate is a method that re-creates the interface unless the backoff after the previous failure has not passed yet.
+// The backoff starts at Interval and doubles with every failure up to maxRecreateBackoff.
+func (w *Watchdog) recreate(log logger.Logger, missing bool) error {
+	if time.Now().Before(w.retryAt) {
+		return nil
+	}
+
+	if missing {
+		log.Infof("tunnel interface is missing, re-creating it")
+	} else {
+		log.Infof("tunnel is still stalled, re-creating the interface")
+	}
+
+	err := w.State.Recreate(w.UserID, w.Persist)
+	w.started = time.Now()
+
+	if err != nil {
+		w.failures++
+		backoff := w.Interval << (w.failures - 1)
+		if backoff > maxRecreateBackoff || backoff <= 0 {
+			backoff = maxRecreateBackoff
+		}
+		w.retryAt = time.Now().Add(backoff)
+		return err
+	}
+
+	w.attempt, w.failures, w.retryAt = 0, 0, time.Time{}
+	return nil
+}
+
+// stalled is a method reporting whether the latest handshake is older than HandshakeTimeout and, since the previous check,
+// something was sent but nothing was received. An idle tunnel sends nothing and is not stalled, while the keepalives
+// of a connected one are sent every auth.PersistentKeepalive seconds even without traffic.
+// A tunnel that has never completed a handshake is given HandshakeTimeout since the watchdog started or re-created the interface.
+func (w *Watchdog) stalled(stats TunnelStats) bool {
+	received, sent := grew(stats.RxBytes, w.rx), grew(stats.TxBytes, w.tx)
+	w.rx, w.tx = stats.RxBytes, stats.TxBytes
+
+	since := stats.LatestHandshake
+	if since.IsZero() {
+		since = w.started
+	}
+
+	return sent && !received && time.Since(since) > w.HandshakeTimeout
+}
+
+// grew reports whether the counter grew since the previous value. The counters start over when the peers are applied again
+// or the interface is re-created, so a counter smaller than before grew if it is not zero.
+func grew(counter int64, previous int64) bool {
+	return counter > previous || counter < previous && counter > 0
+}
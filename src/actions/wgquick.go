@@ -0,0 +1,237 @@
+package actions
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/utils"
+)
+
+// WgQuickBackend configures the Wireguard interface with the wg-quick shell command.
+const WgQuickBackend = "wg-quick"
+
+func init() {
+	RegisterBackend(WgQuickBackend, func(iface string) TunnelBackend {
+		return wgQuickBackend{iface: iface}
+	})
+}
+
+type wgQuickBackend struct {
+	iface string
+}
+
+// Up executes 'wg-quick up' with the profile's configuration file.
+func (b wgQuickBackend) Up(config TunnelConfig) error {
+	return exec.Command("wg-quick", "up", config.ConfigPath).Run()
+}
+
+// Down executes 'wg-quick down' with the profile's configuration file.
+func (b wgQuickBackend) Down(config TunnelConfig) error {
+	return exec.Command("wg-quick", "down", config.ConfigPath).Run()
+}
+
+// Status calls a 'wg show <interface>' shell command, so the other Wireguard interfaces are not taken for the connection.
+func (b wgQuickBackend) Status() (bool, error) {
+	stdout, err := exec.Command("wg", "show", b.iface).CombinedOutput()
+	return err == nil && len(stdout) > 0, nil
+}
+
+func (b wgQuickBackend) Stats() (TunnelStats, error) {
+	return wgShowStats(b.iface)
+}
+
+func (b wgQuickBackend) UpdatePeers(config TunnelConfig, resolve bool) error {
+	return wgSetPeers(b.iface, config, resolve)
+}
+
+// UpdateRoutes sets the allowed IPs of the peers with 'wg set' and replaces the routes of the interface with 'ip route' shell commands.
+// The routes are looked up in the table wg-quick placed them in.
+func (b wgQuickBackend) UpdateRoutes(config TunnelConfig) error {
+	allowedIPs, err := wgSetAllowedIPs(b.iface, config)
+	if err != nil {
+		return err
+	}
+
+	table := "main"
+	if !config.Default {
+		table = strconv.Itoa(config.Table)
+	} else if stdout, _ := exec.Command("ip", "route", "show", "dev", b.iface, "table", strconv.Itoa(auth.DefaultTable)).Output(); len(stdout) > 0 {
+		// wg-quick routes the default tunnel through it's own table once the allowed IPs contain a default route.
+		table = strconv.Itoa(auth.DefaultTable)
+	}
+
+	return ipReplaceRoutes(b.iface, table, allowedIPs)
+}
+
+// wgSetAllowedIPs sets the allowed IPs of the peers of the interface with 'wg set' shell commands, keeping their sessions.
+// Returns the allowed IPs of all the peers.
+func wgSetAllowedIPs(iface string, config TunnelConfig) ([]string, error) {
+	var networks []string
+	for _, peer := range config.Device.Wireguard.GetPeers() {
+		allowedIPs, err := peerAllowedIPs(peer, config)
+		if err != nil {
+			return nil, err
+		}
+
+		if out, err := exec.Command("wg", "set", iface, "peer", peer.GetPubKey(), "allowed-ips", strings.Join(allowedIPs, ",")).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("failed to set allowed ips: %s: %w", strings.TrimSpace(string(out)), err)
+		}
+		networks = append(networks, allowedIPs...)
+	}
+
+	return networks, nil
+}
+
+// ipReplaceRoutes replaces the routes of the interface in the table with the networks using 'ip route' shell commands.
+func ipReplaceRoutes(iface string, table string, networks []string) error {
+	wanted := make(map[string]bool)
+	for _, network := range networks {
+		wanted[network] = true
+	}
+
+	for _, family := range []string{"-4", "-6"} {
+		stdout, err := exec.Command("ip", family, "route", "show", "dev", iface, "table", table).Output()
+		if err != nil {
+			return err
+		}
+
+		for _, line := range strings.Split(strings.TrimSpace(string(stdout)), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+
+			destination := fields[0]
+			if destination == "default" {
+				destination = "0.0.0.0/0"
+				if family == "-6" {
+					destination = "::/0"
+				}
+			} else {
+				destination = utils.HostPrefix(destination)
+			}
+
+			if wanted[destination] {
+				delete(wanted, destination)
+				continue
+			}
+
+			if out, err := exec.Command("ip", family, "route", "del", destination, "dev", iface, "table", table).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to delete route: %s: %w", strings.TrimSpace(string(out)), err)
+			}
+		}
+	}
+
+	for network := range wanted {
+		if out, err := exec.Command("ip", "route", "replace", network, "dev", iface, "table", table).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to add route: %s: %w", strings.TrimSpace(string(out)), err)
+		}
+	}
+
+	return nil
+}
+
+// wgSetPeers removes the peers of the device from the interface and adds them back with 'wg set' shell commands.
+// The endpoints the interface currently uses are kept unless resolve is true.
+func wgSetPeers(iface string, config TunnelConfig, resolve bool) error {
+	endpoints := make(map[string]string)
+	if !resolve {
+		stdout, err := exec.Command("wg", "show", iface, "endpoints").Output()
+		if err != nil {
+			return err
+		}
+
+		for _, line := range strings.Split(strings.TrimSpace(string(stdout)), "\n") {
+			fields := strings.Split(line, "\t")
+			if len(fields) == 2 && fields[1] != "(none)" {
+				endpoints[fields[0]] = fields[1]
+			}
+		}
+	}
+
+	for _, peer := range config.Device.Wireguard.GetPeers() {
+		endpoint, ok := endpoints[peer.GetPubKey()]
+		if !ok {
+			addr, err := net.ResolveUDPAddr("udp", peer.GetEndpoint())
+			if err != nil {
+				return err
+			}
+			endpoint = addr.String()
+		}
+
+		allowedIPs, err := peerAllowedIPs(peer, config)
+		if err != nil {
+			return err
+		}
+
+		if out, err := exec.Command("wg", "set", iface, "peer", peer.GetPubKey(), "remove").CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to remove peer: %s: %w", strings.TrimSpace(string(out)), err)
+		}
+
+		args := []string{"set", iface, "peer", peer.GetPubKey(), "endpoint", endpoint, "persistent-keepalive", "25", "allowed-ips", strings.Join(allowedIPs, ",")}
+		command := exec.Command("wg", args...)
+		if len(peer.GetPsKey()) > 0 {
+			command.Args = append(command.Args, "preshared-key", "/dev/stdin")
+			command.Stdin = strings.NewReader(peer.GetPsKey())
+		}
+
+		if out, err := command.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to add peer: %s: %w", strings.TrimSpace(string(out)), err)
+		}
+	}
+
+	return nil
+}
+
+// wgShowStats calls a 'wg show <interface> dump' shell command and reads the stats of the first peer from it's output.
+func wgShowStats(iface string) (TunnelStats, error) {
+	var stats TunnelStats
+
+	stdout, err := exec.Command("wg", "show", iface, "dump").Output()
+	if err != nil {
+		return stats, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(stdout)), "\n")
+	if len(lines) < 2 {
+		return stats, fmt.Errorf("no peers configured on %s", iface)
+	}
+
+	// public-key preshared-key endpoint allowed-ips latest-handshake transfer-rx transfer-tx persistent-keepalive
+	fields := strings.Split(lines[1], "\t")
+	if len(fields) < 8 {
+		return stats, fmt.Errorf("unexpected 'wg show %s dump' output: %s", iface, lines[1])
+	}
+
+	if fields[2] != "(none)" {
+		stats.Endpoint = fields[2]
+	}
+
+	if fields[3] != "(none)" {
+		stats.AllowedIPs = strings.Split(fields[3], ",")
+	}
+
+	handshake, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return stats, err
+	}
+
+	if handshake > 0 {
+		stats.LatestHandshake = time.Unix(handshake, 0)
+	}
+
+	if stats.RxBytes, err = strconv.ParseInt(fields[5], 10, 64); err != nil {
+		return stats, err
+	}
+
+	if stats.TxBytes, err = strconv.ParseInt(fields[6], 10, 64); err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,40 @@
+package actions
+
+import (
+	"os/exec"
+)
+
+// WindowsBackend installs the profile's configuration file as a tunnel service of WireGuard for Windows.
+const WindowsBackend = "windows"
+
+func init() {
+	RegisterBackend(WindowsBackend, func(iface string) TunnelBackend {
+		return windowsBackend{iface: iface}
+	})
+}
+
+type windowsBackend struct {
+	iface string
+}
+
+func (b windowsBackend) Up(config TunnelConfig) error {
+	if !config.Default {
+		return errNamedTunnel(WindowsBackend)
+	}
+
+	return exec.Command("wireguard", "/installtunnelservice", config.ConfigPath).Run()
+}
+
+func (b windowsBackend) Down(config TunnelConfig) error {
+	return exec.Command("wireguard", "/uninstalltunnelservice", b.iface).Run()
+}
+
+// Status calls a 'wg show <interface>' shell command, so the other Wireguard interfaces are not taken for the connection.
+func (b windowsBackend) Status() (bool, error) {
+	stdout, err := exec.Command("wg", "show", b.iface).CombinedOutput()
+	return err == nil && len(stdout) > 0, nil
+}
+
+func (b windowsBackend) Stats() (TunnelStats, error) {
+	return wgShowStats(b.iface)
+}
@@ -14,6 +14,7 @@ import (
 	"net/http/httputil"
 	"os"
 	"runtime"
+	"time"
 )
 
 // ApiClientWrapper is a structure that wraps forestvpn_api.APIClient to extend it.
@@ -113,6 +114,56 @@ func (w *ApiClientWrapper) GetLocations() ([]forestvpn_api.Location, error) {
 	return loc, nil
 }
 
+// LocationsResponse is a structure representing the response to the conditional request for the locations.
+type LocationsResponse struct {
+	Locations []forestvpn_api.Location
+	// ETag and LastModified are the validators to revalidate the locations with next time.
+	ETag         string
+	LastModified string
+	// NotModified is true if the back-end responded with 304 Not Modified, leaving the Locations empty.
+	NotModified bool
+	// StatusCode is the status of the response, zero if the back-end was not reached.
+	StatusCode int
+}
+
+// GetLocationsIfModified is a method for getting the locations unless they were not modified since the response
+// with given ETag and Last-Modified validators, both optional. The request is given up after the timeout including the retries.
+//
+// See https://github.com/forestvpn/api-client-go/blob/main/docs/GeoApi.md#listlocations for more information.
+func (w *ApiClientWrapper) GetLocationsIfModified(etag string, lastModified string, timeout time.Duration) (LocationsResponse, error) {
+	var response LocationsResponse
+
+	headers := make(map[string]string)
+	if len(etag) > 0 {
+		headers["If-None-Match"] = etag
+	}
+	if len(lastModified) > 0 {
+		headers["If-Modified-Since"] = lastModified
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), forestvpn_api.ContextAccessToken, w.AccessToken), timeout)
+	defer cancel()
+
+	loc, resp, err := w.APIClient.GeoApi.ListLocations(context.WithValue(ctx, headersKey{}, headers)).Execute()
+	if resp != nil {
+		response.StatusCode = resp.StatusCode
+		response.ETag = resp.Header.Get("ETag")
+		response.LastModified = resp.Header.Get("Last-Modified")
+	}
+
+	if response.StatusCode == http.StatusNotModified {
+		response.NotModified = true
+		return response, nil
+	}
+
+	if err != nil {
+		return response, err
+	}
+
+	response.Locations = loc
+	return response, nil
+}
+
 // GetBillingFeatures is a method for getting locations available to the user.
 //
 // See https://github.com/forestvpn/api-client-go/blob/main/docs/BillingApi.md#listbillingfeatures for more information.
@@ -139,9 +190,18 @@ type AuthTransport struct {
 	AccessToken string
 }
 
+// headersKey is the key of the context value holding the extra headers AuthTransport sets on the request made with the context.
+type headersKey struct{}
+
 func (t AuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	req.Header.Set("Authorization", "Bearer "+t.AccessToken)
 
+	if headers, ok := req.Context().Value(headersKey{}).(map[string]string); ok {
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+	}
+
 	// Log the outgoing request
 	if utils.Verbose {
 		// Dump the request in a pretty format
@@ -207,6 +267,27 @@ func (w *ApiClientWrapper) GetDevice(id string) (*forestvpn_api.Device, error) {
 	return dev, nil
 }
 
+// ListDevices is a method to get the devices of the user along with the locations they are connected to.
+//
+// See https://github.com/forestvpn/api-client-go/blob/main/docs/DeviceApi.md#listdevices for more information.
+func (w *ApiClientWrapper) ListDevices() ([]forestvpn_api.Device, error) {
+	auth := context.WithValue(context.Background(), forestvpn_api.ContextAccessToken, w.AccessToken)
+	devices, resp, err := w.APIClient.DeviceApi.ListDevices(auth).Execute()
+	if err != nil {
+		return devices, err
+	}
+
+	if utils.Verbose {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return devices, err
+		}
+		utils.InfoLogger.Printf("%s %s \n %s\n", resp.Request.Method, resp.Request.URL.String(), string(body))
+	}
+
+	return devices, nil
+}
+
 func (w *ApiClientWrapper) DeleteDevice(id string) error {
 	auth := context.WithValue(context.Background(), forestvpn_api.ContextAccessToken, w.AccessToken)
 	resp, err := w.APIClient.DeviceApi.DeleteDevice(auth, id).Execute()
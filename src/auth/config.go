@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/forestvpn/cli/utils"
+)
+
+// ConfigFile is a file to store user's preferences for the connection.
+const ConfigFile = "/config.json"
+
+// Config is a structure representing ConfigFile of the profile. Flags given on the command line take precedence over it.
+type Config struct {
+	// Backend is a name of the backend used to configure the Wireguard interface, e.g. netlink or wg-quick.
+	Backend string `json:"backend,omitempty"`
+	// Interface is a name of the Wireguard interface of the default tunnel, DefaultInterface if empty.
+	Interface string `json:"interface,omitempty"`
+	// Tunnels are the named tunnels of the profile, each with it's own device, location and routing table.
+	Tunnels map[string]Tunnel `json:"tunnels,omitempty"`
+	// Routes are the networks routed through the tunnel in addition to the allowed IPs of the peer.
+	Routes []string `json:"routes,omitempty"`
+	// Exclude are the networks kept outside of the tunnel.
+	Exclude []string `json:"exclude,omitempty"`
+	// Domains are the domain rules whose addresses are routed through the tunnel, e.g. sso.example.com.
+	Domains []string `json:"domains,omitempty"`
+	// BypassDomains are the domain rules whose addresses are kept outside of the tunnel.
+	BypassDomains []string `json:"bypass_domains,omitempty"`
+	// DomainsOnly routes only the Routes and the addresses of the Domains through the tunnel instead of the allowed IPs of the peer.
+	DomainsOnly bool `json:"domains_only,omitempty"`
+	// IPv6 is what to do with the IPv6 traffic when the location offers no IPv6 default route, IPv6Allow if empty.
+	IPv6 string `json:"ipv6,omitempty"`
+	// MultiHop routes the default tunnel through the HopTunnel to the entry location.
+	MultiHop bool `json:"multi_hop,omitempty"`
+	// HooksDir is the directory holding the pre-up.d, post-up.d, pre-down.d and post-down.d directories of the hooks, if set.
+	HooksDir string `json:"hooks_dir,omitempty"`
+	// MTU pins the MTU of the tunnels instead of discovering it toward their endpoints, if set.
+	MTU int `json:"mtu,omitempty"`
+	// Resolved are the addresses the domain rules were last resolved to, stored in DomainsFile.
+	Resolved ResolvedDomains `json:"-"`
+}
+
+// The ways of handling the IPv6 traffic when the location offers no IPv6 default route.
+const (
+	// IPv6Block rejects the IPv6 traffic outside of the tunnel while connected.
+	IPv6Block = "block"
+	// IPv6Tunnel routes the IPv6 traffic through the tunnel anyway.
+	IPv6Tunnel = "tunnel"
+	// IPv6Allow leaves the IPv6 traffic outside of the tunnel.
+	IPv6Allow = "allow"
+)
+
+// LoadConfig is a function that reads the local configuration file of the user with given user ID along with the resolved addresses of it's domain rules.
+// A missing file results into empty Config.
+func LoadConfig(userID ProfileID) (Config, error) {
+	var config Config
+	path := ProfilesDir + string(userID) + ConfigFile
+
+	resolved, err := LoadResolvedDomains(userID)
+	if err != nil {
+		return config, err
+	}
+	config.Resolved = resolved
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return config, nil
+	}
+
+	data, err := readFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	err = json.Unmarshal(data, &config)
+	return config, err
+}
+
+// SaveConfig is a function that writes the local configuration file of the user with given user ID.
+func SaveConfig(userID ProfileID, config Config) error {
+	data, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return JsonDump(data, ProfilesDir+string(userID)+ConfigFile)
+}
+
+// AllowedIPs is a method that merges the allowed IPs of the peer with the Routes, the addresses of the Domains and the IPv6 default route if IPv6 is IPv6Tunnel,
+// and removes the Exclude networks and the addresses of the BypassDomains along with the network of an active SSH client,
+// so the session is not dropped once the tunnel is up.
+//
+// In the DomainsOnly mode the allowed IPs of the peer are replaced by the Routes and the addresses of the Domains.
+// The allowed IPs of the peer are kept if some of the Domains are wildcards, since their addresses are only known once they are resolved:
+// the traffic to the other addresses is then kept outside of the tunnel by the domain sets of the default tunnel instead.
+func (c Config) AllowedIPs(peerAllowedIPs []string) ([]string, error) {
+	routes := append(append([]string{}, c.Routes...), c.Resolved.Addresses(c.Domains)...)
+	if c.IPv6 == IPv6Tunnel && !c.DomainsOnly {
+		routes = append(routes, "::/0")
+	}
+	if c.DomainsOnly && len(Wildcards(c.Domains)) == 0 {
+		peerAllowedIPs = nil
+	}
+	exclude := append(append([]string{}, c.Exclude...), c.Resolved.Addresses(c.BypassDomains)...)
+	if activeSShClient := utils.GetActiveSshClient(); len(activeSShClient) > 0 {
+		exclude = append(exclude, activeSShClient)
+	}
+
+	return utils.AllowedIPs(peerAllowedIPs, routes, exclude)
+}
+
+// UsesDomainSets reports whether some of the domain rules are wildcards, which the default tunnel matches with the domain sets.
+func (c Config) UsesDomainSets() bool {
+	return len(Wildcards(c.Domains)) > 0 || len(Wildcards(c.BypassDomains)) > 0
+}
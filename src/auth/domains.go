@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// DomainsFile is a file to store the addresses the domain rules of the profile were last resolved to.
+const DomainsFile = "/domains.json"
+
+var domainRule = regexp.MustCompile(`^([a-z0-9_]([a-z0-9_-]*[a-z0-9])?\.)*[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// ResolvedDomains is a structure mapping the domain rules to the addresses they resolved to.
+type ResolvedDomains map[string][]string
+
+// NormalizeDomain is a function that lowercases the domain rule and strips it's trailing dot.
+// The rule is a domain name, e.g. sso.example.com, or a wildcard matching the domain along with it's subdomains, e.g. *.example.com.
+// Only the addresses of a name can be resolved, so the wildcards are matched in the answers of the resolver instead, see IsWildcard.
+func NormalizeDomain(rule string) (string, error) {
+	rule = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(rule)), ".")
+	if !domainRule.MatchString(strings.TrimPrefix(rule, "*.")) {
+		return "", fmt.Errorf("invalid domain: %s, expected a domain name or a wildcard, e.g. sso.example.com or *.example.com", rule)
+	}
+	return rule, nil
+}
+
+// IsWildcard reports whether the normalized domain rule matches the subdomains of the domain, e.g. *.example.com.
+func IsWildcard(rule string) bool {
+	return strings.HasPrefix(rule, "*.")
+}
+
+// Wildcards returns the wildcard rules of the domain rules.
+func Wildcards(rules []string) []string {
+	var wildcards []string
+	for _, rule := range rules {
+		if IsWildcard(rule) {
+			wildcards = append(wildcards, rule)
+		}
+	}
+	return wildcards
+}
+
+// LoadResolvedDomains is a function that reads the resolved addresses of the domain rules of the user with given user ID.
+// A missing file results into no addresses.
+func LoadResolvedDomains(userID ProfileID) (ResolvedDomains, error) {
+	resolved := make(ResolvedDomains)
+	path := ProfilesDir + string(userID) + DomainsFile
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return resolved, nil
+	}
+
+	data, err := readFile(path)
+	if err != nil {
+		return resolved, err
+	}
+
+	err = json.Unmarshal(data, &resolved)
+	return resolved, err
+}
+
+// SaveResolvedDomains is a function that writes the resolved addresses of the domain rules of the user with given user ID.
+func SaveResolvedDomains(userID ProfileID, resolved ResolvedDomains) error {
+	data, err := json.MarshalIndent(resolved, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return JsonDump(data, ProfilesDir+string(userID)+DomainsFile)
+}
+
+// Addresses is a method returning the addresses the domain rules resolved to.
+func (r ResolvedDomains) Addresses(rules []string) []string {
+	var addresses []string
+	for _, rule := range rules {
+		addresses = append(addresses, r[rule]...)
+	}
+	return addresses
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// HistoryFile is a file to journal the connections of the profile, one JSON object per line.
+const HistoryFile = "/history.jsonl"
+
+// The events of the connection journal.
+const (
+	HistoryUp     = "up"
+	HistoryDown   = "down"
+	HistoryFailed = "failed"
+)
+
+// HistoryEntry is a structure representing an event of the connection journal.
+type HistoryEntry struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Interface  string    `json:"interface"`
+	Tunnel     string    `json:"tunnel,omitempty"`
+	Backend    string    `json:"backend"`
+	Location   string    `json:"location"`
+	LocationID string    `json:"location_id"`
+	Country    string    `json:"country"`
+	// Duration is how long the connection lasted in seconds, known for the down events of the connections established by fvpn.
+	Duration int64 `json:"duration,omitempty"`
+	RxBytes  int64 `json:"rx_bytes,omitempty"`
+	TxBytes  int64 `json:"tx_bytes,omitempty"`
+	// Reason is why the connection ended or failed.
+	Reason string `json:"reason,omitempty"`
+}
+
+// AppendHistory is a function that appends the entry to the connection journal of the user with given user ID.
+func AppendHistory(userID ProfileID, entry HistoryEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(ProfilesDir+string(userID)+HistoryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// LoadHistory is a function that reads the entries of the connection journal of the user with given user ID recorded from since until until.
+// A zero time leaves the range open on that side. A missing file results into no entries and the malformed lines are skipped.
+func LoadHistory(userID ProfileID, since time.Time, until time.Time) ([]HistoryEntry, error) {
+	file, err := os.Open(ProfilesDir + string(userID) + HistoryFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		if !since.IsZero() && entry.Time.Before(since) || !until.IsZero() && entry.Time.After(until) {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
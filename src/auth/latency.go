@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// LatencyFile is a file to cache the endpoints of the locations and the latencies they were last probed with.
+const LatencyFile = "/latency.json"
+
+// Endpoint is a structure representing the Wireguard endpoint a location was seen with.
+type Endpoint struct {
+	Address string    `json:"address"`
+	SeenAt  time.Time `json:"seen_at"`
+}
+
+// Latency is a structure representing the result of probing the endpoint of a location.
+type Latency struct {
+	// RTT is the round-trip time to the endpoint, unknown if it was unreachable.
+	RTT       time.Duration `json:"rtt,omitempty"`
+	Reachable bool          `json:"reachable"`
+	ProbedAt  time.Time     `json:"probed_at"`
+}
+
+// LatencyCache is a structure holding the endpoints and the latencies of the locations keyed by location ID.
+type LatencyCache struct {
+	Endpoints map[string]Endpoint `json:"endpoints"`
+	Latencies map[string]Latency  `json:"latencies"`
+}
+
+// LoadLatencyCache is a function that reads the latency cache of the user with given user ID.
+// A missing or malformed file results into an empty cache.
+func LoadLatencyCache(userID ProfileID) LatencyCache {
+	cache := LatencyCache{Endpoints: make(map[string]Endpoint), Latencies: make(map[string]Latency)}
+
+	data, err := os.ReadFile(ProfilesDir + string(userID) + LatencyFile)
+	if err != nil || json.Unmarshal(data, &cache) != nil {
+		return LatencyCache{Endpoints: make(map[string]Endpoint), Latencies: make(map[string]Latency)}
+	}
+
+	if cache.Endpoints == nil {
+		cache.Endpoints = make(map[string]Endpoint)
+	}
+	if cache.Latencies == nil {
+		cache.Latencies = make(map[string]Latency)
+	}
+
+	return cache
+}
+
+// SaveLatencyCache is a function that writes the latency cache of the user with given user ID.
+func SaveLatencyCache(userID ProfileID, cache LatencyCache) error {
+	data, err := json.MarshalIndent(cache, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return JsonDump(data, ProfilesDir+string(userID)+LatencyFile)
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+)
+
+// LocationsFile is a file in the AppDir to cache the locations shared by the profiles along with the validators to revalidate them.
+const LocationsFile = "locations.json"
+
+// LocationsCache is a structure holding the locations as last fetched from the API.
+type LocationsCache struct {
+	Locations []forestvpn_api.Location `json:"locations"`
+	// ETag and LastModified are the validators of the response the locations were fetched with.
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+// LoadLocationsCache is a function that reads the cached locations, nil if they were never cached.
+func LoadLocationsCache() (*LocationsCache, error) {
+	path := AppDir + LocationsFile
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	data, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := new(LocationsCache)
+	return cache, json.Unmarshal(data, cache)
+}
+
+// SaveLocationsCache is a function that writes the locations to the cache.
+func SaveLocationsCache(cache LocationsCache) error {
+	data, err := json.MarshalIndent(cache, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(AppDir, 0755); err != nil {
+		return err
+	}
+
+	return JsonDump(data, AppDir+LocationsFile)
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// SessionsFile is a file to store the details of the established connections of the profile, keyed by interface name.
+const SessionsFile = "/sessions.json"
+
+// Session is a structure representing an established connection.
+type Session struct {
+	Backend        string    `json:"backend"`
+	ConnectedSince time.Time `json:"connected_since"`
+	// Endpoints are the addresses the endpoints of the peers resolved to on connecting, keyed by endpoint, e.g. de.example.com:51820.
+	Endpoints map[string]string `json:"endpoints,omitempty"`
+}
+
+// LoadSessions is a function that reads the established connections of the user with given user ID.
+// A missing file results into no sessions.
+func LoadSessions(userID ProfileID) (map[string]Session, error) {
+	sessions := make(map[string]Session)
+	path := ProfilesDir + string(userID) + SessionsFile
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return sessions, nil
+	}
+
+	data, err := readFile(path)
+	if err != nil {
+		return sessions, err
+	}
+
+	err = json.Unmarshal(data, &sessions)
+	return sessions, err
+}
+
+// SaveSession is a function that records the connection established on the interface.
+func SaveSession(userID ProfileID, iface string, session Session) error {
+	sessions, err := LoadSessions(userID)
+	if err != nil {
+		return err
+	}
+
+	sessions[iface] = session
+	return dumpSessions(userID, sessions)
+}
+
+// RemoveSession is a function that forgets the connection of the interface once it is set down.
+func RemoveSession(userID ProfileID, iface string) error {
+	sessions, err := LoadSessions(userID)
+	if err != nil {
+		return err
+	}
+
+	delete(sessions, iface)
+	return dumpSessions(userID, sessions)
+}
+
+func dumpSessions(userID ProfileID, sessions map[string]Session) error {
+	data, err := json.MarshalIndent(sessions, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return JsonDump(data, ProfilesDir+string(userID)+SessionsFile)
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultInterface is a name of the Wireguard interface of the default tunnel unless configured otherwise.
+const DefaultInterface = "fvpn0"
+
+// DefaultTable is the routing table of the default tunnel, the same wg-quick uses by default.
+const DefaultTable = 51820
+
+// HopTunnel is the name of the tunnel to the entry location of the multi-hop connection, which the default tunnel is routed through.
+const HopTunnel = "hop"
+
+// MTUFile is a file in the directory of the tunnel to store the MTU discovered toward it's endpoint.
+const MTUFile = "/mtu"
+
+// TunnelsDir is a directory of the profile holding a directory with the device and Wireguard configuration file per named tunnel.
+const TunnelsDir = "/tunnels/"
+
+// tunnelName limits the names of the tunnels, so the interface names fit into 15 characters.
+var tunnelName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,9}$`)
+
+// Tunnel is a structure representing a named tunnel of the profile.
+// It is connected to the location of it's own device and it's routes are placed in it's own routing table,
+// so the traffic from it's addresses leaves through it while the default tunnel routes the rest.
+type Tunnel struct {
+	Interface string `json:"interface"`
+	Table     int    `json:"table"`
+}
+
+// InterfaceName is a method returning the Wireguard interface name of the tunnel with given name, the default tunnel if the name is empty.
+func (c Config) InterfaceName(name string) string {
+	if len(name) == 0 {
+		if len(c.Interface) > 0 {
+			return c.Interface
+		}
+		return DefaultInterface
+	}
+
+	if tunnel, ok := c.Tunnels[name]; ok {
+		return tunnel.Interface
+	}
+	return "fvpn-" + name
+}
+
+// Table is a method returning the routing table of the tunnel with given name, DefaultTable for the default tunnel.
+func (c Config) Table(name string) int {
+	if tunnel, ok := c.Tunnels[name]; ok && len(name) > 0 {
+		return tunnel.Table
+	}
+	return DefaultTable
+}
+
+// AddTunnel is a method that registers the named tunnel with the next free routing table, unless it is registered already.
+func (c *Config) AddTunnel(name string) (Tunnel, error) {
+	if tunnel, ok := c.Tunnels[name]; ok {
+		return tunnel, nil
+	}
+
+	if !tunnelName.MatchString(name) {
+		return Tunnel{}, fmt.Errorf("invalid tunnel name: %s, expected up to 10 lowercase letters, digits or dashes", name)
+	}
+
+	table := DefaultTable
+	for _, tunnel := range c.Tunnels {
+		if tunnel.Table > table {
+			table = tunnel.Table
+		}
+	}
+
+	if c.Tunnels == nil {
+		c.Tunnels = make(map[string]Tunnel)
+	}
+
+	tunnel := Tunnel{Interface: c.InterfaceName(name), Table: table + 1}
+	c.Tunnels[name] = tunnel
+	return tunnel, nil
+}
+
+// TunnelDir is a function returning the directory with the device and Wireguard configuration file of the tunnel with given name,
+// the profile directory for the default tunnel.
+func TunnelDir(userID ProfileID, name string) string {
+	if len(name) == 0 {
+		return ProfilesDir + string(userID)
+	}
+	return ProfilesDir + string(userID) + TunnelsDir + name
+}
+
+// TunnelExists is a function to check whether the device of the tunnel with given name is created.
+func TunnelExists(userID ProfileID, name string) bool {
+	_, err := os.Stat(TunnelDir(userID, name) + DeviceFile)
+	return err == nil
+}
+
+// WireguardConfigPath is a function returning the path of the Wireguard configuration file of the tunnel.
+// The file is named after the interface, as wg-quick derives the interface name from it.
+func WireguardConfigPath(userID ProfileID, name string, iface string) string {
+	return TunnelDir(userID, name) + "/" + iface + ".conf"
+}
+
+// LoadMTU is a function that returns the MTU of the tunnel with given name: the one pinned in the profile configuration,
+// or the one last discovered toward it's endpoint, or 0 to leave it to the backend.
+func LoadMTU(userID ProfileID, name string) (int, error) {
+	config, err := LoadConfig(userID)
+	if err != nil || config.MTU > 0 {
+		return config.MTU, err
+	}
+
+	data, err := os.ReadFile(TunnelDir(userID, name) + MTUFile)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// SaveDiscoveredMTU is a function that stores the MTU discovered toward the endpoint of the tunnel with given name.
+func SaveDiscoveredMTU(userID ProfileID, name string, mtu int) error {
+	return os.WriteFile(TunnelDir(userID, name)+MTUFile, []byte(strconv.Itoa(mtu)+"\n"), 0644)
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,6 +20,10 @@ import (
 	"github.com/google/uuid"
 )
 
+// PersistentKeepalive is the interval in seconds the peers are kept alive at, so an idle tunnel keeps handshaking
+// and a NAT between the host and the endpoint keeps it's mapping.
+const PersistentKeepalive = 25
+
 // AccountsMapFile is a file to dump the AccountsMap structure that holds the mappings from user logged in emails to uuids.
 const AccountsMapFile = ".accounts.json"
 
@@ -108,6 +113,18 @@ func (p *Profile) DB() *UserDB {
 }
 
 func (p *Profile) CreateLocalWireguardConfigurationFile(device *forestvpn_api.Device) error {
+	return CreateWireguardConfigurationFile(device, p.ID, "")
+}
+
+// CreateWireguardConfigurationFile is a function that writes the Wireguard configuration file of the tunnel with given name from the device.
+// The named tunnels leave DNS to the default one and place their routes in their own table,
+// which the traffic from the addresses of the tunnel is routed by.
+func CreateWireguardConfigurationFile(device *forestvpn_api.Device, userID ProfileID, name string) error {
+	profileConfig, err := LoadConfig(userID)
+	if err != nil {
+		return err
+	}
+
 	config := ini.Empty()
 
 	interfaceSection, err := config.NewSection("Interface")
@@ -122,34 +139,74 @@ func (p *Profile) CreateLocalWireguardConfigurationFile(device *forestvpn_api.De
 	if err != nil {
 		return err
 	}
-	_, err = interfaceSection.NewKey("DNS", strings.Join(device.GetDns()[:], ","))
+
+	mtu, err := LoadMTU(userID, name)
 	if err != nil {
 		return err
 	}
 
-	for _, peer := range device.Wireguard.GetPeers() {
-		peerSection, err := config.NewSection("Peer")
+	if mtu > 0 {
+		_, err = interfaceSection.NewKey("MTU", fmt.Sprint(mtu))
 		if err != nil {
 			return err
 		}
+	}
 
-		var allowedIps []string
-		if utils.Os == "darwin" || utils.Os == "windows" {
-			allowedIps = append(allowedIps, "0.0.0.0/0")
-		} else {
-			allowedIps = peer.GetAllowedIps()
-			activeSShClient := utils.GetActiveSshClient()
+	// The DNS servers of the device are only reachable through the tunnel, which the DomainsOnly mode keeps the queries out of.
+	if len(name) == 0 {
+		if !profileConfig.DomainsOnly {
+			_, err = interfaceSection.NewKey("DNS", strings.Join(device.GetDns()[:], ","))
 			if err != nil {
 				return err
 			}
-			if len(activeSShClient) > 0 {
-				allowedIps, err = utils.ExcludeDisallowedIps(allowedIps, activeSShClient)
-				if err != nil {
-					return err
-				}
+		}
+	} else {
+		table := profileConfig.Table(name)
+		_, err = interfaceSection.NewKey("Table", fmt.Sprint(table))
+		if err != nil {
+			return err
+		}
+		// The encrypted traffic is marked as the one of the default tunnel is, so it is not routed through the default tunnel.
+		if utils.Os == "linux" {
+			_, err = interfaceSection.NewKey("FwMark", fmt.Sprint(DefaultTable))
+			if err != nil {
+				return err
 			}
 		}
 
+		var postUp, preDown []string
+		for _, ip := range device.GetIps() {
+			prefix := utils.HostPrefix(ip)
+			postUp = append(postUp, fmt.Sprintf("ip rule add from %s table %d", prefix, table))
+			preDown = append(preDown, fmt.Sprintf("ip rule del from %s table %d", prefix, table))
+		}
+
+		_, err = interfaceSection.NewKey("PostUp", strings.Join(postUp, "; "))
+		if err != nil {
+			return err
+		}
+		_, err = interfaceSection.NewKey("PreDown", strings.Join(preDown, "; "))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, peer := range device.Wireguard.GetPeers() {
+		peerSection, err := config.NewSection("Peer")
+		if err != nil {
+			return err
+		}
+
+		allowedIps := peer.GetAllowedIps()
+		if utils.Os == "darwin" || utils.Os == "windows" {
+			allowedIps = []string{"0.0.0.0/0"}
+		}
+
+		allowedIps, err = profileConfig.AllowedIPs(allowedIps)
+		if err != nil {
+			return err
+		}
+
 		_, err = peerSection.NewKey("AllowedIPs", strings.Join(allowedIps, ", "))
 		if err != nil {
 			return err
@@ -158,6 +215,10 @@ func (p *Profile) CreateLocalWireguardConfigurationFile(device *forestvpn_api.De
 		if err != nil {
 			return err
 		}
+		_, err = peerSection.NewKey("PersistentKeepalive", strconv.Itoa(PersistentKeepalive))
+		if err != nil {
+			return err
+		}
 		_, err = peerSection.NewKey("PublicKey", peer.GetPubKey())
 		if err != nil {
 			return err
@@ -171,7 +232,7 @@ func (p *Profile) CreateLocalWireguardConfigurationFile(device *forestvpn_api.De
 		}
 	}
 
-	path := ProfilesDir + string(p.ID) + WireguardConfig
+	path := WireguardConfigPath(userID, name, profileConfig.InterfaceName(name))
 	fmt.Println(path)
 	err = config.SaveTo(path)
 	if err != nil {
@@ -24,9 +24,9 @@ var AppDir = home + "/.forestvpn/"
 // Read more: https://github.com/forestvpn/api-client-go.
 const DeviceFile string = "/device.json"
 
-// WireguardConfig is a Wireguard configuration file.
+// WireguardConfig is a Wireguard configuration file of the default tunnel with the DefaultInterface.
 //
-// It's being rewrittten per location change.
+// It's being rewrittten per location change. See WireguardConfigPath for the other tunnels.
 const WireguardConfig = "/fvpn0.conf"
 
 var ProfilesDir = AppDir + "profiles/"
@@ -90,8 +90,13 @@ func readFile(filepath string) ([]byte, error) {
 
 // LoadDevice is a function that reads local device file depending on the user ID provided and returns it as a forestvpn_api.Device.
 func LoadDevice(userID ProfileID) (*forestvpn_api.Device, error) {
+	return LoadTunnelDevice(userID, "")
+}
+
+// LoadTunnelDevice is a function that reads the local device file of the tunnel with given name, the default tunnel if the name is empty.
+func LoadTunnelDevice(userID ProfileID, name string) (*forestvpn_api.Device, error) {
 	var device *forestvpn_api.Device
-	data, err := readFile(ProfilesDir + string(userID) + DeviceFile)
+	data, err := readFile(TunnelDir(userID, name) + DeviceFile)
 	if err != nil {
 		return nil, err
 	}
@@ -105,12 +110,22 @@ func LoadDevice(userID ProfileID) (*forestvpn_api.Device, error) {
 
 // UpdateProfileDevice is a helper function to quickly update the local device file of the logged in (active) user.
 func UpdateProfileDevice(device *forestvpn_api.Device, userID ProfileID) error {
+	return UpdateTunnelDevice(device, userID, "")
+}
+
+// UpdateTunnelDevice is a function to update the local device file of the tunnel with given name, creating the directory of the tunnel if needed.
+func UpdateTunnelDevice(device *forestvpn_api.Device, userID ProfileID, name string) error {
 	data, err := json.MarshalIndent(device, "", "    ")
 	if err != nil {
 		return err
 	}
 
-	return JsonDump(data, ProfilesDir+string(userID)+DeviceFile)
+	dir := TunnelDir(userID, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return JsonDump(data, dir+DeviceFile)
 }
 
 // LoadBillingFeatures is a function to read local billing features from file for the user with id value of given user id.
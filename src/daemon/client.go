@@ -0,0 +1,199 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+	"github.com/forestvpn/cli/actions"
+	"github.com/forestvpn/cli/auth"
+)
+
+// Client is a structure used to send the requests to the daemon over it's Unix socket.
+type Client struct {
+	// Warn is called with the warnings the daemon sends along with the responses.
+	Warn func(message string)
+
+	http *http.Client
+}
+
+// NewClient is a factory function that returns a Client of the daemon listening on SocketPath.
+func NewClient() *Client {
+	path := SocketPath
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}
+
+	return &Client{Warn: actions.PrintWarning, http: &http.Client{Transport: transport}}
+}
+
+// Available is a function to check whether the daemon is listening on SocketPath.
+func Available() bool {
+	conn, err := net.DialTimeout("unix", SocketPath, time.Second)
+	if err != nil {
+		return false
+	}
+
+	conn.Close()
+	return true
+}
+
+// Status is a method to get the state of the connection of the tunnel with given name, the default tunnel if the name is empty.
+func (c *Client) Status(name string) (Status, error) {
+	var status Status
+	return status, c.do(http.MethodGet, "/v1/status?name="+url.QueryEscape(name), nil, &status)
+}
+
+// Up is a method asking the daemon to establish the connection.
+func (c *Client) Up(request UpRequest) (Status, error) {
+	var status Status
+	return status, c.do(http.MethodPost, "/v1/up", request, &status)
+}
+
+// Down is a method asking the daemon to terminate the connection of the tunnel with given name.
+func (c *Client) Down(name string) (DownResponse, error) {
+	var response DownResponse
+	return response, c.do(http.MethodPost, "/v1/down", TunnelRequest{Name: name}, &response)
+}
+
+// Location is a method to get the location of the device of the tunnel with given name.
+func (c *Client) Location(name string) (forestvpn_api.Location, error) {
+	var location forestvpn_api.Location
+	return location, c.do(http.MethodGet, "/v1/location?name="+url.QueryEscape(name), nil, &location)
+}
+
+// SetLocation is a method asking the daemon to set the location with the UUID or name for the tunnel with given name.
+// The cached locations are fetched again if refresh is set.
+func (c *Client) SetLocation(name string, arg string, refresh bool) (forestvpn_api.Location, error) {
+	var location forestvpn_api.Location
+	return location, c.do(http.MethodPost, "/v1/location", LocationRequest{Name: name, Location: arg, Refresh: refresh}, &location)
+}
+
+// SetFastestLocation is a method asking the daemon to set the location with the lowest latency, optionally within the country,
+// for the tunnel with given name. The cached locations are fetched again if refresh is set.
+func (c *Client) SetFastestLocation(name string, country string, refresh bool) (forestvpn_api.Location, error) {
+	var location forestvpn_api.Location
+	return location, c.do(http.MethodPost, "/v1/location", LocationRequest{Name: name, Fastest: true, Country: country, Refresh: refresh}, &location)
+}
+
+// Locations is a method to get the locations available for the user, filtered and sorted according to the options.
+// The cached locations are fetched again if refresh is set.
+func (c *Client) Locations(options actions.ListOptions, refresh bool) ([]actions.LocationLatency, error) {
+	query := url.Values{}
+	if refresh {
+		query.Set("refresh", "1")
+	}
+	query.Set("country", options.Country)
+	query.Set("sort", options.Sort)
+	if options.Premium {
+		query.Set("premium", "1")
+	}
+	if options.Free {
+		query.Set("free", "1")
+	}
+
+	var locations []actions.LocationLatency
+	return locations, c.do(http.MethodGet, "/v1/locations?"+query.Encode(), nil, &locations)
+}
+
+// Ping is a method asking the daemon to probe the latency of the location with the UUID or name,
+// or of the locations optionally filtered by country. The cached latencies are probed again if refresh is set,
+// and the cached locations are fetched again if refreshLocations is set.
+func (c *Client) Ping(arg string, country string, refresh bool, refreshLocations bool) ([]actions.LocationLatency, error) {
+	query := url.Values{}
+	query.Set("location", arg)
+	query.Set("country", country)
+	if refresh {
+		query.Set("refresh", "1")
+	}
+	if refreshLocations {
+		query.Set("refresh_locations", "1")
+	}
+
+	var latencies []actions.LocationLatency
+	return latencies, c.do(http.MethodGet, "/v1/ping?"+query.Encode(), nil, &latencies)
+}
+
+// Account is a method to get the account the daemon is signed in with.
+func (c *Client) Account() (Account, error) {
+	var account Account
+	return account, c.do(http.MethodGet, "/v1/account", nil, &account)
+}
+
+// History is a method to get the connection journal of the profile recorded between since and until, a zero time leaving the range open.
+func (c *Client) History(since time.Time, until time.Time) ([]auth.HistoryEntry, error) {
+	query := url.Values{}
+	if !since.IsZero() {
+		query.Set("since", since.Format(time.RFC3339))
+	}
+	if !until.IsZero() {
+		query.Set("until", until.Format(time.RFC3339))
+	}
+
+	var entries []auth.HistoryEntry
+	return entries, c.do(http.MethodGet, "/v1/history?"+query.Encode(), nil, &entries)
+}
+
+// do is a method sending the request with the body encoded as JSON and decoding the response into v.
+// The errors sent by the daemon are mapped back to the errors of this package and actions.SubscriptionError.
+func (c *Client) do(method string, path string, body interface{}, v interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequest(method, "http://fvpnd"+path, &payload)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+		return err
+	}
+	defer response.Body.Close()
+
+	if c.Warn != nil {
+		for _, warning := range response.Header.Values(warningHeader) {
+			c.Warn(warning)
+		}
+	}
+
+	if response.StatusCode != http.StatusOK {
+		var e errorResponse
+		if err := json.NewDecoder(response.Body).Decode(&e); err != nil {
+			return fmt.Errorf("fvpnd responded with %s", response.Status)
+		}
+
+		switch e.Code {
+		case codeAlreadyUp:
+			return ErrAlreadyUp
+		case codeAlreadyDown:
+			return ErrAlreadyDown
+		case codeConnected:
+			return ErrConnected
+		case codeSubscription:
+			return &actions.SubscriptionError{Message: e.Error}
+		}
+		return errors.New(e.Error)
+	}
+
+	return json.NewDecoder(response.Body).Decode(v)
+}
@@ -0,0 +1,17 @@
+package daemon
+
+import (
+	"errors"
+)
+
+// ErrAlreadyUp is returned when the connection is requested to be set up while it is up.
+var ErrAlreadyUp = errors.New("state is already up and running")
+
+// ErrAlreadyDown is returned when the connection is requested to be set down while it is down.
+var ErrAlreadyDown = errors.New("state is already down")
+
+// ErrConnected is returned when a request requires the connection to be down.
+var ErrConnected = errors.New("the connection is up")
+
+// ErrUnavailable is returned by the client when there is no daemon listening on the socket.
+var ErrUnavailable = errors.New("fvpnd is not running")
@@ -0,0 +1,109 @@
+// daemon is a package implementing fvpnd, a long-running process that owns the Wireguard connection of the current profile,
+// keeps it's access token fresh and caches it's billing features.
+// The daemon is controlled with JSON requests over a Unix socket, so the CLI commands become thin clients of it
+// and the users of the socket group can control the connection without root privileges.
+package daemon
+
+import (
+	"time"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+	"github.com/forestvpn/cli/actions"
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/utils"
+)
+
+// TokenRefreshInterval is how often the daemon refreshes the access token of the profile.
+const TokenRefreshInterval = 5 * time.Minute
+
+// BillingCacheTTL is how long the daemon trusts the cached billing feature before asking the API again.
+const BillingCacheTTL = 10 * time.Minute
+
+// SocketPath is a path to the Unix socket the daemon listens on and the clients connect to.
+var SocketPath = DefaultSocketPath()
+
+// DefaultSocketPath returns the path of the daemon socket native to the platform.
+func DefaultSocketPath() string {
+	if utils.Os == "linux" {
+		return "/run/fvpnd.sock"
+	}
+	return auth.AppDir + "fvpnd.sock"
+}
+
+// Status is a structure representing the state of the connection controlled by the daemon.
+type Status struct {
+	actions.ConnectionStatus
+	// Warning is a subscription warning to show to the user once connected.
+	Warning string `json:"warning,omitempty"`
+}
+
+// TunnelRequest is a structure holding the name of the tunnel the request is about, empty for the default tunnel.
+type TunnelRequest struct {
+	Name string `json:"name,omitempty"`
+}
+
+// UpRequest is a structure holding the options of establishing a connection.
+type UpRequest struct {
+	Name       string `json:"name,omitempty"`
+	Persist    bool   `json:"persist"`
+	KillSwitch bool   `json:"kill_switch"`
+	AllowLAN   bool   `json:"allow_lan"`
+	// Routes, Exclude, Domains and BypassDomains replace the split tunneling lists of the profile unless they are nil.
+	Routes        *[]string `json:"routes,omitempty"`
+	Exclude       *[]string `json:"exclude,omitempty"`
+	Domains       *[]string `json:"domains,omitempty"`
+	BypassDomains *[]string `json:"bypass_domains,omitempty"`
+	// DomainsOnly replaces the domains-only mode of the profile unless it is nil.
+	DomainsOnly *bool `json:"domains_only,omitempty"`
+	// IPv6 replaces the way of handling the IPv6 traffic of the profile unless it is nil.
+	IPv6 *string `json:"ipv6,omitempty"`
+	// MTU pins the MTU of the tunnels of the profile unless it is nil, 0 discovers it again.
+	MTU *int `json:"mtu,omitempty"`
+	// Via replaces the entry location of the multi-hop connection of the default tunnel unless it is nil, empty connects directly.
+	Via *string `json:"via,omitempty"`
+	// Backend is a name of the backend given on the command line of the client, empty for the one of the profile.
+	// It must resolve into the backend of the daemon.
+	Backend string `json:"backend,omitempty"`
+}
+
+// DownResponse is a structure describing what the daemon did to set the connection down.
+type DownResponse struct {
+	// KillSwitchRemoved is true if the connection was already down and only a leftover kill switch was removed.
+	KillSwitchRemoved bool `json:"kill_switch_removed"`
+	// PersistenceDisabled is true if the connection was already down and only the unit bringing it up on boot was disabled.
+	PersistenceDisabled bool `json:"persistence_disabled"`
+}
+
+// LocationRequest is a structure holding the UUID or name of the location to set as default for the tunnel with given name.
+// With Fastest set, the location with the lowest latency is chosen instead, optionally within Country.
+type LocationRequest struct {
+	Name     string `json:"name,omitempty"`
+	Location string `json:"location"`
+	Fastest  bool   `json:"fastest,omitempty"`
+	Country  string `json:"country,omitempty"`
+	// Refresh fetches the locations from the API even if the cached ones are fresh.
+	Refresh bool `json:"refresh,omitempty"`
+}
+
+// Account is a structure representing the signed-in account of the daemon.
+type Account struct {
+	Email          string                       `json:"email"`
+	BillingFeature forestvpn_api.BillingFeature `json:"billing_feature"`
+}
+
+// Error codes sent along with the error messages, so the clients can tell the errors apart.
+const (
+	codeAlreadyUp    = "already_up"
+	codeAlreadyDown  = "already_down"
+	codeConnected    = "connected"
+	codeSubscription = "subscription"
+)
+
+// warningHeader is a response header carrying a warning to show to the user, e.g. the locations being used from the cache.
+const warningHeader = "Fvpn-Warning"
+
+// errorResponse is a structure sent by the daemon when a request fails.
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
@@ -0,0 +1,620 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+	"github.com/forestvpn/cli/actions"
+	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/utils"
+	"github.com/forestvpn/goauthlib/pkg/logger"
+)
+
+// Server is a structure holding the signed-in profile, it's API client and billing cache shared by the requests to the daemon.
+// The changes of the connections are serialized with the monitors, the API calls and the probing run concurrently.
+type Server struct {
+	// Backend is a name of the backend given on the command line, empty for the one of the profile.
+	Backend string
+	// Watchdog enables the actions.Watchdog reconnecting the stalled tunnel while it is up.
+	Watchdog bool
+	// Roaming enables the actions.NetworkMonitor reconnecting the tunnel once the network of the host changes while it is up.
+	Roaming bool
+
+	logger logger.Logger
+	// ctx is the context of ListenAndServe, the monitors of the connections are stopped once it is done.
+	ctx context.Context
+
+	// mu serializes the changes of the connections, shared with the watchdogs, the network monitors and the domain refreshers.
+	mu sync.Mutex
+	// stopMonitors holds the functions stopping the watchdogs, the network monitors and the domain refreshers by tunnel name, guarded by mu.
+	stopMonitors map[string]context.CancelFunc
+
+	// sessionMu guards the profile, it's API client and the billing cache.
+	sessionMu      sync.Mutex
+	profile        *auth.Profile
+	client         actions.AuthClientWrapper
+	billing        forestvpn_api.BillingFeature
+	billingUpdated time.Time
+}
+
+// call is a structure holding a request to the daemon along with the profile and the API client it is served with.
+type call struct {
+	*http.Request
+	profile *auth.Profile
+	client  actions.AuthClientWrapper
+	// warnings collect the warnings of the client while serving the request, sent back in the warningHeader.
+	warnings []string
+}
+
+// NewServer is a factory function that returns a Server controlling the tunnels of the current profile with the backend.
+func NewServer(backend string) *Server {
+	return &Server{Backend: backend, Watchdog: true, Roaming: true, logger: auth.NewSimpleLogger(), stopMonitors: make(map[string]context.CancelFunc)}
+}
+
+// ListenAndServe is a method that signs the current profile in, listens on the Unix socket at the path and serves the requests until the context is done.
+// If the group is not empty, the socket is made accessible to it's members.
+// The connection is left as is when the daemon stops, so restarting the daemon does not interrupt it.
+func (s *Server) ListenAndServe(ctx context.Context, path string, group string) error {
+	s.ctx = ctx
+	if err := s.signIn(); err != nil {
+		return err
+	}
+
+	listener, err := listen(path, group)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	if err := s.watchConnectedTunnels(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for name := range s.stopMonitors {
+			s.stopMonitoring(name)
+		}
+	}()
+
+	server := &http.Server{Handler: s.handler()}
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	s.logger.Infof("fvpnd is listening on %s", path)
+	ticker := time.NewTicker(TokenRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			return server.Shutdown(shutdownCtx)
+		case err := <-errs:
+			return err
+		case <-ticker.C:
+			if err := s.signIn(); err != nil {
+				s.logger.WithError(err).Errorf("failed to refresh the access token: %+v", err)
+			}
+		}
+	}
+}
+
+// listen is a function that creates the Unix socket at the path, replacing a stale one left by a crashed daemon.
+func listen(path string, group string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("fvpnd is already listening on %s", path)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := os.FileMode(0600)
+	if len(group) > 0 {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+
+		gid, err := strconv.Atoi(g.Gid)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+
+		if err := os.Chown(path, -1, gid); err != nil {
+			listener.Close()
+			return nil, err
+		}
+		mode = 0660
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// signIn is a method that signs the current profile in and refreshes the API client with a fresh access token.
+// It is called periodically, so the daemon picks up the profile switched with 'fvpn account login'.
+func (s *Server) signIn() error {
+	profile := auth.OpenUserDB().CurrentUser()
+	if err := profile.SignIn(utils.ApiHost); err != nil {
+		return err
+	}
+
+	client, err := actions.GetAuthClientWrapper(profile, utils.ApiHost)
+	if err != nil {
+		return err
+	}
+
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	if s.profile == nil || s.profile.ID != profile.ID {
+		s.billingUpdated = time.Time{}
+	}
+
+	s.profile, s.client = profile, client
+	return nil
+}
+
+// watchConnectedTunnels is a method that starts monitoring the tunnels of the profile which are already up when the daemon starts.
+func (s *Server) watchConnectedTunnels(ctx context.Context) error {
+	config, err := auth.LoadConfig(s.profile.ID)
+	if err != nil {
+		return err
+	}
+
+	names := []string{""}
+	for name := range config.Tunnels {
+		names = append(names, name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range names {
+		if state, err := s.state(s.profile.ID, name); err == nil && state.GetStatus() {
+			s.startMonitoring(ctx, state, s.profile.ID, false)
+		}
+	}
+
+	return nil
+}
+
+// startMonitoring is a method that starts the watchdog and the network monitor of the connection of the State and the refresher of the domain rules of the profile
+// until the connection is set down or the context is done. The caller holds mu.
+func (s *Server) startMonitoring(ctx context.Context, state actions.State, userID auth.ProfileID, persist bool) {
+	s.stopMonitoring(state.Tunnel)
+	ctx, cancel := context.WithCancel(ctx)
+	s.stopMonitors[state.Tunnel] = cancel
+
+	if s.Watchdog {
+		watchdog := actions.NewWatchdog(state, userID, persist)
+		watchdog.Locker = &s.mu
+		go watchdog.Run(ctx)
+	}
+
+	if s.Roaming {
+		monitor := actions.NewNetworkMonitor(state, userID)
+		monitor.Locker = &s.mu
+		go monitor.Run(ctx)
+	}
+
+	refresher := actions.NewDomainRefresher(state, userID)
+	refresher.Locker = &s.mu
+	go refresher.Run(ctx)
+}
+
+// stopMonitoring is a method that stops the watchdog, the network monitor and the domain refresher of the tunnel with given name, if any. The caller holds mu.
+func (s *Server) stopMonitoring(name string) {
+	if cancel, ok := s.stopMonitors[name]; ok {
+		cancel()
+		delete(s.stopMonitors, name)
+	}
+}
+
+// billingFeature is a method returning the cached billing feature of the profile of the call, fetching it again once the cache is stale or the feature is expired.
+func (s *Server) billingFeature(c *call) (forestvpn_api.BillingFeature, error) {
+	s.sessionMu.Lock()
+	b, updated := s.billing, s.billingUpdated
+	s.sessionMu.Unlock()
+
+	if time.Since(updated) < BillingCacheTTL && !auth.BillingFeatureExpired(b) {
+		return b, nil
+	}
+
+	b, err := c.client.GetUnexpiredOrMostRecentBillingFeature(c.profile.ID)
+	if err != nil {
+		return b, err
+	}
+
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	if s.profile.ID == c.profile.ID {
+		s.billing, s.billingUpdated = b, time.Now()
+	}
+	return b, nil
+}
+
+// state is a method returning the State of the tunnel of the profile with given name, the default tunnel if the name is empty.
+func (s *Server) state(userID auth.ProfileID, name string) (actions.State, error) {
+	return actions.GetState(userID, name, s.Backend)
+}
+
+// status is a method that describes the connection of the tunnel of the profile with given name.
+func (s *Server) status(userID auth.ProfileID, name string) (Status, error) {
+	state, err := s.state(userID, name)
+	if err != nil {
+		return Status{}, err
+	}
+
+	status, err := state.Describe(userID)
+	return Status{ConnectionStatus: status}, err
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/status", s.handle(http.MethodGet, s.handleStatus))
+	mux.HandleFunc("/v1/up", s.handle(http.MethodPost, s.handleUp))
+	mux.HandleFunc("/v1/down", s.handle(http.MethodPost, s.handleDown))
+	mux.HandleFunc("/v1/location", s.handle("", s.handleLocation))
+	mux.HandleFunc("/v1/locations", s.handle(http.MethodGet, s.handleLocations))
+	mux.HandleFunc("/v1/ping", s.handle(http.MethodGet, s.handlePing))
+	mux.HandleFunc("/v1/account", s.handle(http.MethodGet, s.handleAccount))
+	mux.HandleFunc("/v1/history", s.handle(http.MethodGet, s.handleHistory))
+	return mux
+}
+
+// handle wraps the handler so it only accepts the method, if any, and serves the request with the current profile and a client collecting it's warnings.
+// The handlers lock mu only around the changes of the connections.
+func (s *Server) handle(method string, handler func(*call) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(method) > 0 && r.Method != method {
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+			return
+		}
+
+		s.sessionMu.Lock()
+		c := &call{Request: r, profile: s.profile, client: s.client}
+		s.sessionMu.Unlock()
+
+		c.client.Warn = func(message string) {
+			s.logger.Warnf("%s", message)
+			c.warnings = append(c.warnings, message)
+		}
+
+		response, err := handler(c)
+		for _, warning := range c.warnings {
+			w.Header().Add(warningHeader, strings.ReplaceAll(warning, "\n", " "))
+		}
+
+		if err != nil {
+			s.logger.WithError(err).Debugf("failed to %+v", err)
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+func (s *Server) handleStatus(r *call) (interface{}, error) {
+	return s.status(r.profile.ID, r.URL.Query().Get("name"))
+}
+
+func (s *Server) handleUp(r *call) (interface{}, error) {
+	var request UpRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+
+	state, err := s.state(r.profile.ID, request.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(request.Backend) > 0 {
+		requested, err := actions.GetState(r.profile.ID, request.Name, request.Backend)
+		if err != nil {
+			return nil, err
+		}
+
+		if requested.Backend != state.Backend {
+			return nil, fmt.Errorf("fvpnd controls the tunnels with the %s backend, restart it with --backend %s or stop it to use the %s backend", state.Backend, requested.Backend, requested.Backend)
+		}
+	}
+
+	if state.GetStatus() {
+		return nil, ErrAlreadyUp
+	}
+
+	b, err := s.billingFeature(r)
+	if err != nil {
+		return nil, err
+	}
+
+	device, err := auth.LoadTunnelDevice(r.profile.ID, request.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	warning, err := actions.CheckSubscription(b, device.GetLocation())
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Via != nil {
+		if len(request.Name) > 0 {
+			return nil, fmt.Errorf("multi-hop connections only support the default tunnel")
+		}
+
+		if _, err := r.client.SetViaLocation(r.profile.ID, *request.Via); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.setUp(r.profile.ID, state, request); err != nil {
+		return nil, err
+	}
+
+	time.Sleep(1 * time.Second)
+
+	status, err := s.status(r.profile.ID, request.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !status.Connected {
+		// The interface is gone, so only the kill switch is removed if setting the rest down fails.
+		if err := state.SetDown(r.profile.ID); err != nil {
+			s.logger.WithError(err).Debugf("failed to %+v", err)
+			if err := actions.DisableKillSwitch(); err != nil {
+				s.logger.WithError(err).Debugf("failed to %+v", err)
+			}
+		}
+		return nil, errors.New("unexpected error: state.status is false after state is up")
+	}
+
+	// The connection may be set down by another request in the meantime, so it is only monitored while it is up.
+	if !state.GetStatus() {
+		return nil, errors.New("the connection was set down while connecting")
+	}
+
+	s.logger.Infof("connected %s to %s", state.WiregaurdInterface, status.Location.GetName())
+	s.startMonitoring(s.ctx, state, r.profile.ID, request.Persist)
+	status.Warning = warning
+	return status, nil
+}
+
+// setUp is a method that applies the split tunnel and the MTU of the request and sets the connection of the State up, exclusively of the other changes of the connections.
+func (s *Server) setUp(userID auth.ProfileID, state actions.State, request UpRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state.GetStatus() {
+		return ErrAlreadyUp
+	}
+
+	split := actions.SplitTunnel{Routes: request.Routes, Exclude: request.Exclude, Domains: request.Domains, BypassDomains: request.BypassDomains, DomainsOnly: request.DomainsOnly, IPv6: request.IPv6}
+	if split.IsSet() {
+		if err := actions.SetSplitTunnel(userID, request.Name, split); err != nil {
+			return err
+		}
+	}
+
+	if request.MTU != nil {
+		if err := actions.SetMTU(userID, *request.MTU); err != nil {
+			return err
+		}
+	}
+
+	options := actions.UpOptions{Persist: request.Persist, KillSwitch: request.KillSwitch, AllowLAN: request.AllowLAN}
+	return state.SetUp(userID, options)
+}
+
+func (s *Server) handleDown(r *call) (interface{}, error) {
+	var request TunnelRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+
+	state, err := s.state(r.profile.ID, request.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.setDown(r.profile.ID, state, request.Name)
+	if err != nil || response.PersistenceDisabled || response.KillSwitchRemoved {
+		return response, err
+	}
+
+	if utils.Os == "windows" {
+		time.Sleep(1 * time.Second)
+	}
+
+	if state.GetStatus() {
+		return nil, errors.New("unexpected error: state.status is true after state is down")
+	}
+
+	s.logger.Infof("disconnected %s", state.WiregaurdInterface)
+	return DownResponse{}, nil
+}
+
+// setDown is a method that stops monitoring the connection of the State and sets it down, exclusively of the other changes of the connections.
+// If the connection is down already, the persistence or the kill switch left behind is removed instead.
+func (s *Server) setDown(userID auth.ProfileID, state actions.State, name string) (DownResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !state.GetStatus() {
+		if actions.PersistenceEnabled(state.WiregaurdInterface) {
+			return DownResponse{PersistenceDisabled: true}, actions.DisablePersistence(state.WiregaurdInterface)
+		}
+		if len(name) == 0 && actions.KillSwitchEnabled() {
+			return DownResponse{KillSwitchRemoved: true}, actions.DisableKillSwitch()
+		}
+		return DownResponse{}, ErrAlreadyDown
+	}
+
+	s.stopMonitoring(name)
+	return DownResponse{}, state.SetDown(userID)
+}
+
+func (s *Server) handleLocation(r *call) (interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+		device, err := auth.LoadTunnelDevice(r.profile.ID, r.URL.Query().Get("name"))
+		if err != nil {
+			return nil, err
+		}
+		return device.GetLocation(), nil
+	case http.MethodPost:
+		var request LocationRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			return nil, err
+		}
+
+		if len(request.Name) == 0 || auth.TunnelExists(r.profile.ID, request.Name) {
+			state, err := s.state(r.profile.ID, request.Name)
+			if err != nil {
+				return nil, err
+			}
+
+			if state.GetStatus() {
+				return nil, ErrConnected
+			}
+		}
+
+		client := r.client
+		client.RefreshLocations = request.Refresh
+
+		if request.Fastest {
+			fastest, err := client.SetFastestLocation(r.profile.ID, request.Name, request.Country, actions.DefaultProbeTimeout)
+			if err != nil {
+				return nil, err
+			}
+			return fastest.Location.Location, nil
+		}
+
+		location, err := client.SetDefaultLocation(r.profile.ID, request.Name, request.Location)
+		if err != nil {
+			return nil, err
+		}
+		return location.Location, nil
+	}
+
+	return nil, fmt.Errorf("method not allowed: %s", r.Method)
+}
+
+// handleLocations responds with the locations filtered and sorted according to the country, premium, free and sort query parameters.
+func (s *Server) handleLocations(r *call) (interface{}, error) {
+	query := r.URL.Query()
+	options := actions.ListOptions{
+		Country: query.Get("country"),
+		Premium: len(query.Get("premium")) > 0,
+		Free:    len(query.Get("free")) > 0,
+		Sort:    query.Get("sort"),
+	}
+
+	client := r.client
+	client.RefreshLocations = len(query.Get("refresh")) > 0
+	return client.QueryLocations(r.profile.ID, options)
+}
+
+// handlePing responds with the latencies of the location given by UUID or name, or of the locations optionally filtered by country.
+// The cached latencies are probed again if refresh is set, and the cached locations are fetched again if refresh_locations is set.
+func (s *Server) handlePing(r *call) (interface{}, error) {
+	query := r.URL.Query()
+	client := r.client
+	client.RefreshLocations = len(query.Get("refresh_locations")) > 0
+	return client.PingLocations(r.profile.ID, query.Get("location"), query.Get("country"), actions.DefaultProbeTimeout, len(query.Get("refresh")) > 0)
+}
+
+// handleHistory responds with the connection journal of the profile recorded between the optional since and until times in the RFC 3339 format.
+func (s *Server) handleHistory(r *call) (interface{}, error) {
+	var since, until time.Time
+	var err error
+
+	if value := r.URL.Query().Get("since"); len(value) > 0 {
+		if since, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if value := r.URL.Query().Get("until"); len(value) > 0 {
+		if until, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := auth.LoadHistory(r.profile.ID, since, until)
+	if entries == nil {
+		entries = []auth.HistoryEntry{}
+	}
+	return entries, err
+}
+
+func (s *Server) handleAccount(r *call) (interface{}, error) {
+	b, err := s.billingFeature(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return Account{Email: string(r.profile.Email), BillingFeature: b}, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError sends the error with a code the client maps back to the same error.
+func writeError(w http.ResponseWriter, err error) {
+	response := errorResponse{Error: err.Error()}
+	code := http.StatusInternalServerError
+
+	var subscriptionErr *actions.SubscriptionError
+	switch {
+	case errors.Is(err, ErrAlreadyUp):
+		response.Code, code = codeAlreadyUp, http.StatusConflict
+	case errors.Is(err, ErrAlreadyDown):
+		response.Code, code = codeAlreadyDown, http.StatusConflict
+	case errors.Is(err, ErrConnected):
+		response.Code, code = codeConnected, http.StatusConflict
+	case errors.As(err, &subscriptionErr):
+		response.Code, code = codeSubscription, http.StatusPaymentRequired
+	}
+
+	writeJSON(w, code, response)
+}
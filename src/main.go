@@ -1,24 +1,31 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
+	"os/signal"
 	"runtime"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
+	forestvpn_api "github.com/forestvpn/api-client-go"
 	"github.com/forestvpn/cli/actions"
 	"github.com/forestvpn/cli/auth"
+	"github.com/forestvpn/cli/daemon"
 	"github.com/forestvpn/cli/timezone"
 	"github.com/forestvpn/cli/utils"
-	"github.com/google/uuid"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 
 	"github.com/getsentry/sentry-go"
+	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v2"
 )
 
@@ -30,13 +37,24 @@ var appVersion string
 // See https://docs.sentry.io/product/sentry-basics/dsn-explainer/ for more information.
 const Dsn = "https://ef875c1346ed49289812f9df5a44f03f@sentry.fvpn.uk/8"
 
-const url = "https://forestvpn.com/checkout/"
-
 func main() {
 	// email is user's email address used to sign in or sign up on the Firebase.
 	var email string
 	// country is stores prompted country name to filter locations by country.
 	var country string
+	// backend is a name of the backend used to configure the Wireguard interface.
+	var backend string
+	// name is a name of the tunnel to control, empty for the default tunnel.
+	var name string
+	var nameFlag = &cli.StringFlag{
+		Name:        "name",
+		Usage:       "control the tunnel named `NAME` with it's own location instead of the default one",
+		Destination: &name,
+	}
+	var refreshFlag = &cli.BoolFlag{
+		Name:  "refresh",
+		Usage: "fetch the locations from the API even if the cached ones are fresh",
+	}
 	var logger = auth.NewSimpleLogger()
 
 	err := auth.Init()
@@ -76,8 +94,56 @@ func main() {
 				Value:       false,
 				Destination: &utils.Verbose,
 			},
+			&cli.StringFlag{
+				Name:        "backend",
+				Usage:       fmt.Sprintf("configure the tunnel with `BACKEND`: %s (default: %s)", strings.Join(actions.Backends(), ", "), actions.DefaultBackend()),
+				Destination: &backend,
+				EnvVars:     []string{"FVPN_BACKEND"},
+			},
+			&cli.StringFlag{
+				Name:        "socket",
+				Usage:       "talk to fvpnd over the Unix socket at `PATH`",
+				Value:       daemon.SocketPath,
+				Destination: &daemon.SocketPath,
+				EnvVars:     []string{"FVPN_SOCKET"},
+			},
 		},
 		Commands: []*cli.Command{
+			{
+				Name:  "daemon",
+				Usage: "run fvpnd to control the connection of the current account over the socket",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "group",
+						Usage: "let the members of `GROUP` use the socket",
+						Value: "",
+					},
+					&cli.BoolFlag{
+						Name:  "watchdog",
+						Usage: "reconnect once the handshake goes stale while the tunnel sends but receives nothing",
+						Value: true,
+					},
+					&cli.BoolFlag{
+						Name:  "roaming",
+						Usage: "reconnect once the network changes, e.g. on switching Wi-Fi networks or resuming from suspend",
+						Value: true,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+					defer stop()
+
+					server := daemon.NewServer(backend)
+					server.Watchdog = c.Bool("watchdog")
+					server.Roaming = c.Bool("roaming")
+					if err := server.ListenAndServe(ctx, daemon.SocketPath, c.String("group")); err != nil {
+						logger.WithError(err).Debugf("failed to %+v", err)
+						return err
+					}
+
+					return nil
+				},
+			},
 			{
 				Name:  "account",
 				Usage: "manage ForestVPN accounts",
@@ -93,6 +159,17 @@ func main() {
 						Name:  "status",
 						Usage: "see logged-in account info",
 						Action: func(c *cli.Context) error {
+							if daemon.Available() {
+								account, err := daemon.NewClient().Account()
+								if err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
+								}
+
+								printAccountStatus(account.Email, account.BillingFeature)
+								return nil
+							}
+
 							profile := auth.OpenUserDB().CurrentUser()
 							if err = profile.SignIn(utils.ApiHost); err != nil {
 								logger.WithError(err).Debugf("failed to %+v", err)
@@ -138,30 +215,7 @@ func main() {
 								return err
 							}
 
-							expiryDate := b.GetExpiryDate()
-							now := time.Now()
-							left := expiryDate.Sub(now)
-							caser := cases.Title(language.English)
-							plan := caser.String(strings.Split(b.GetBundleId(), ".")[2])
-							fmt.Printf("Logged-in as %s\n", profile.Email)
-							fmt.Printf("Plan: %s\n", plan)
-							tz, err := utils.GetLocalTimezone()
-
-							if err != nil {
-								sentry.CaptureException(err)
-								_, offset := now.Zone()
-
-								tz = timezone.GetGmtTimezone(offset)
-							}
-
-							if now.After(expiryDate) {
-								t := now.Sub(expiryDate)
-								fmt.Printf("Status: expired %s ago at %s %s\n", utils.HumanizeDuration(t), expiryDate.Format("2006-01-02 15:04:05"), tz)
-							} else {
-								fmt.Printf("Status: expires in %s at %s %s\n", utils.HumanizeDuration(left), expiryDate.Format("2006-01-02 15:04:05"), tz)
-
-							}
-
+							printAccountStatus(string(profile.Email), b)
 							return nil
 						},
 					},
@@ -235,7 +289,11 @@ func main() {
 								return err
 							}
 
-							state := actions.State{WiregaurdInterface: "fvpn0"}
+							state, err := actions.GetState(profile.ID, "", backend)
+							if err != nil {
+								logger.WithError(err).Debugf("failed to %+v", err)
+								return err
+							}
 							status := state.GetStatus()
 							if status {
 								fmt.Println("Please, set down the connection before attempting to log out.")
@@ -259,21 +317,163 @@ func main() {
 						Name:  "up",
 						Usage: "connect to the ForestVPN location",
 						Flags: []cli.Flag{
+							nameFlag,
 							&cli.BoolFlag{
 								Name:    "persist",
 								Usage:   "Persist VPN connnection through reboots",
 								Value:   false,
 								Aliases: []string{"p"},
 							},
+							&cli.BoolFlag{
+								Name:  "kill-switch",
+								Usage: "block all the traffic outside of the tunnel until 'fvpn state down'",
+								Value: false,
+							},
+							&cli.BoolFlag{
+								Name:  "allow-lan",
+								Usage: "let the traffic to the local networks through the kill switch",
+								Value: false,
+							},
+							&cli.StringSliceFlag{
+								Name:  "route",
+								Usage: "route the IPv4 or IPv6 `CIDR` through the tunnel in addition to the networks of the location, saved for the next connections, empty to clear",
+							},
+							&cli.StringSliceFlag{
+								Name:  "exclude",
+								Usage: "keep the IPv4 or IPv6 `CIDR` outside of the tunnel, saved for the next connections, empty to clear",
+							},
+							&cli.StringSliceFlag{
+								Name:  "domain",
+								Usage: "route the addresses of the `DOMAIN`, e.g. sso.example.com or *.example.com, through the tunnel, saved for the next connections, empty to clear",
+							},
+							&cli.StringSliceFlag{
+								Name:  "bypass-domain",
+								Usage: "keep the addresses of the `DOMAIN` outside of the tunnel, saved for the next connections, empty to clear",
+							},
+							&cli.BoolFlag{
+								Name:  "domains-only",
+								Usage: "route only the domains and the routes through the tunnel instead of all the traffic, saved for the next connections, =false to clear",
+							},
+							&cli.StringFlag{
+								Name:  "ipv6",
+								Usage: "`MODE` of the IPv6 traffic when the location offers no IPv6 route: block, tunnel or allow, saved for the next connections",
+							},
+							&cli.StringFlag{
+								Name:  "via",
+								Usage: "connect to the location through the entry `LOCATION` given by UUID or name, saved for the next connections, empty to connect directly",
+							},
+							&cli.IntFlag{
+								Name:  "mtu",
+								Usage: "pin the `MTU` of the tunnels instead of discovering it toward the endpoint, saved for the next connections, 0 to discover it again",
+							},
+							&cli.BoolFlag{
+								Name:  "userspace",
+								Usage: "run the tunnel inside of fvpn without root privileges and expose it as local SOCKS5 and HTTP proxies",
+								Value: false,
+							},
+							&cli.StringFlag{
+								Name:  "socks",
+								Usage: "listen for SOCKS5 proxy clients on `ADDRESS` in userspace mode, empty to disable",
+								Value: "127.0.0.1:1080",
+							},
+							&cli.StringFlag{
+								Name:  "http",
+								Usage: "listen for HTTP proxy clients on `ADDRESS` in userspace mode, empty to disable",
+								Value: "127.0.0.1:8080",
+							},
 						},
 						Action: func(c *cli.Context) error {
+							userspace := c.Bool("userspace")
+							if userspace && len(name) > 0 {
+								return errors.New("userspace mode only supports the default tunnel")
+							}
+
+							split := actions.SplitTunnel{
+								Routes:        stringSliceFlag(c, "route"),
+								Exclude:       stringSliceFlag(c, "exclude"),
+								Domains:       stringSliceFlag(c, "domain"),
+								BypassDomains: stringSliceFlag(c, "bypass-domain"),
+							}
+							if c.IsSet("domains-only") {
+								domainsOnly := c.Bool("domains-only")
+								split.DomainsOnly = &domainsOnly
+							}
+							if c.IsSet("ipv6") {
+								ipv6 := c.String("ipv6")
+								split.IPv6 = &ipv6
+							}
+
+							var mtu *int
+							if c.IsSet("mtu") {
+								value := c.Int("mtu")
+								mtu = &value
+							}
+
+							var via *string
+							if c.IsSet("via") {
+								if len(name) > 0 || userspace {
+									return errors.New("multi-hop connections only support the default tunnel outside of userspace mode")
+								}
+								value := c.String("via")
+								via = &value
+							}
+
+							if !userspace && daemon.Available() {
+								status, err := daemon.NewClient().Up(daemon.UpRequest{
+									Name:          name,
+									Backend:       backend,
+									Persist:       c.Bool("persist"),
+									KillSwitch:    c.Bool("kill-switch"),
+									AllowLAN:      c.Bool("allow-lan"),
+									Routes:        split.Routes,
+									Exclude:       split.Exclude,
+									Domains:       split.Domains,
+									BypassDomains: split.BypassDomains,
+									DomainsOnly:   split.DomainsOnly,
+									IPv6:          split.IPv6,
+									MTU:           mtu,
+									Via:           via,
+								})
+								if errors.Is(err, daemon.ErrAlreadyUp) {
+									fmt.Println("State is already up and running")
+									os.Exit(1)
+								}
+
+								var subscriptionErr *actions.SubscriptionError
+								if errors.As(err, &subscriptionErr) {
+									fmt.Println(subscriptionErr)
+									os.Exit(1)
+								}
+
+								if err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
+								}
+
+								if len(status.Warning) > 0 {
+									fmt.Println(status.Warning)
+								}
+
+								country := status.Location.GetCountry()
+								fmt.Printf("Connected to %s, %s\n", status.Location.GetName(), country.GetName())
+								return nil
+							}
+
 							profile := auth.OpenUserDB().CurrentUser()
 							if err = profile.SignIn(utils.ApiHost); err != nil {
 								logger.WithError(err).Debugf("failed to %+v", err)
 								return err
 							}
-							state := actions.State{WiregaurdInterface: "fvpn0"}
+							state, err := actions.GetState(profile.ID, name, backend)
+							if err != nil {
+								logger.WithError(err).Debugf("failed to %+v", err)
+								return err
+							}
 							if state.GetStatus() {
+								// The userspace tunnel would fight the interface over the session of the device and be routed through it.
+								if userspace {
+									return fmt.Errorf("%s uses the device, set it down with 'fvpn state down' first", state.WiregaurdInterface)
+								}
 								fmt.Println("State is already up and running")
 								os.Exit(1)
 							}
@@ -290,37 +490,68 @@ func main() {
 								return err
 							}
 
-							device, err := auth.LoadDevice(profile.ID)
+							device, err := auth.LoadTunnelDevice(profile.ID, name)
 							if err != nil {
 								logger.WithError(err).Debugf("failed to %+v", err)
 								return err
 							}
 
-							bid := b.GetBundleId()
 							location := device.GetLocation()
-							now := time.Now()
-							exp := b.GetExpiryDate()
-							left := exp.Sub(now)
-							days := int64(left.Hours() / 24)
-
-							if now.After(exp) {
-								if actions.IsPremiumLocation(location) && bid == "com.forestvpn.premium" {
-									fmt.Println("The location you were using is now unavailable, as your paid subscription has ended.")
-									fmt.Printf("You can keep using ForestVPN once you watch an ad in our mobile app, or simply go Premium at %s.\n", url)
-									os.Exit(1)
-								} else {
-									fmt.Println("Your 30-minute session is over.")
-									fmt.Printf("You can keep using ForestVPN once you watch an ad in our mobile app, or simply go Premium at %s.\n", url)
-									os.Exit(1)
+							warning, err := actions.CheckSubscription(b, location)
+							if err != nil {
+								fmt.Println(err)
+								os.Exit(1)
+							}
+
+							if len(warning) > 0 {
+								fmt.Println(warning)
+							}
+
+							if userspace {
+								tunnel, err := actions.SetUpUserspace(profile.ID, c.String("socks"), c.String("http"))
+								if err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
+								}
+
+								country := location.GetCountry()
+								fmt.Printf("Connected to %s, %s\n", location.GetName(), country.GetName())
+								return tunnel.Wait()
+							}
+
+							if split.IsSet() {
+								if err := actions.SetSplitTunnel(profile.ID, name, split); err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
+								}
+							}
+
+							if via != nil {
+								if _, err := client.SetViaLocation(profile.ID, *via); err != nil {
+									var subscriptionErr *actions.SubscriptionError
+									if errors.As(err, &subscriptionErr) {
+										fmt.Println(subscriptionErr)
+										os.Exit(1)
+									}
+
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
 								}
-							} else if bid == "com.forestvpn.freemium" && int(left.Minutes()) < 5 {
-								fmt.Println("You currently have less than 5 minutes of free trial left.")
-							} else if days == 3 && left.Hours() == 0 || days < 3 && bid == "com.forestvpn.premium" {
-								fmt.Println("Your premium subscription will end in less than 3 days.")
 							}
 
-							persist := c.Bool("persist")
-							err = state.SetUp(profile.ID, persist)
+							if mtu != nil {
+								if err := actions.SetMTU(profile.ID, *mtu); err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
+								}
+							}
+
+							options := actions.UpOptions{
+								Persist:    c.Bool("persist"),
+								KillSwitch: c.Bool("kill-switch"),
+								AllowLAN:   c.Bool("allow-lan"),
+							}
+							err = state.SetUp(profile.ID, options)
 
 							if err != nil {
 								logger.WithError(err).Debugf("failed to %+v", err)
@@ -333,7 +564,13 @@ func main() {
 								country := location.GetCountry()
 								fmt.Printf("Connected to %s, %s\n", location.GetName(), country.GetName())
 							} else {
-								logger.WithError(err).Debugf("failed to %+v", err)
+								// The interface is gone, so only the kill switch is removed if setting the rest down fails.
+								if err := state.SetDown(profile.ID); err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									if err := actions.DisableKillSwitch(); err != nil {
+										logger.WithError(err).Debugf("failed to %+v", err)
+									}
+								}
 								return errors.New("unexpected error: state.status is false after state is up")
 							}
 
@@ -343,14 +580,41 @@ func main() {
 					{
 						Name:        "down",
 						Description: "disconnect from the ForestVPN location",
+						Flags:       []cli.Flag{nameFlag},
 						Action: func(ctx *cli.Context) error {
+							if daemon.Available() {
+								response, err := daemon.NewClient().Down(name)
+								if errors.Is(err, daemon.ErrAlreadyDown) {
+									fmt.Println("State is already down")
+									os.Exit(1)
+								}
+
+								if err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
+								}
+
+								if response.PersistenceDisabled {
+									fmt.Println("Persistent connection disabled")
+								} else if response.KillSwitchRemoved {
+									fmt.Println("Kill switch removed")
+								} else {
+									fmt.Println("Disconnected")
+								}
+								return nil
+							}
+
 							profile := auth.OpenUserDB().CurrentUser()
 							if err = profile.SignIn(utils.ApiHost); err != nil {
 								logger.WithError(err).Debugf("failed to %+v", err)
 								return err
 							}
 
-							state := actions.State{WiregaurdInterface: "fvpn0"}
+							state, err := actions.GetState(profile.ID, name, backend)
+							if err != nil {
+								logger.WithError(err).Debugf("failed to %+v", err)
+								return err
+							}
 
 							if state.GetStatus() {
 								err = state.SetDown(profile.ID)
@@ -370,6 +634,20 @@ func main() {
 								}
 
 								fmt.Println("Disconnected")
+							} else if actions.PersistenceEnabled(state.WiregaurdInterface) {
+								if err := actions.DisablePersistence(state.WiregaurdInterface); err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
+								}
+
+								fmt.Println("Persistent connection disabled")
+							} else if len(name) == 0 && actions.KillSwitchEnabled() {
+								if err := actions.DisableKillSwitch(); err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
+								}
+
+								fmt.Println("Kill switch removed")
 							} else {
 								fmt.Println("State is already down")
 								os.Exit(1)
@@ -381,37 +659,191 @@ func main() {
 					{
 						Name:  "status",
 						Usage: "see wether connection is active",
+						Flags: []cli.Flag{
+							nameFlag,
+							&cli.BoolFlag{
+								Name:  "json",
+								Usage: "print the status as JSON, e.g. for monitoring",
+								Value: false,
+							},
+						},
 						Action: func(ctx *cli.Context) error {
+							if daemon.Available() {
+								status, err := daemon.NewClient().Status(name)
+								if err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
+								}
+
+								return printConnectionStatus(status.ConnectionStatus, ctx.Bool("json"))
+							}
+
 							profile := auth.OpenUserDB().CurrentUser()
 							if err = profile.SignIn(utils.ApiHost); err != nil {
 								logger.WithError(err).Debugf("failed to %+v", err)
 								return err
 							}
 
-							state := actions.State{WiregaurdInterface: "fvpn0"}
+							state, err := actions.GetState(profile.ID, name, backend)
+							if err != nil {
+								logger.WithError(err).Debugf("failed to %+v", err)
+								return err
+							}
 
-							if state.GetStatus() {
-								device, err := auth.LoadDevice(profile.ID)
+							status, err := state.Describe(profile.ID)
+							if err != nil {
+								logger.WithError(err).Debugf("failed to %+v", err)
+								return err
+							}
 
-								if err != nil {
-									logger.WithError(err).Debugf("failed to %+v", err)
+							return printConnectionStatus(status, ctx.Bool("json"))
+						},
+					},
+					{
+						Name:  "history",
+						Usage: "see when, where and for how long the connections were established",
+						Flags: []cli.Flag{
+							nameFlag,
+							&cli.StringFlag{
+								Name:  "since",
+								Usage: "show the connections since `TIME`, e.g. 2024-05-01, 2024-05-01 13:30 or 7d",
+							},
+							&cli.StringFlag{
+								Name:  "until",
+								Usage: "show the connections until `TIME`, e.g. 2024-05-31 or 24h",
+							},
+							&cli.BoolFlag{
+								Name:  "json",
+								Usage: "print the history as JSON",
+								Value: false,
+							},
+						},
+						Action: func(ctx *cli.Context) error {
+							var since, until time.Time
+							now := time.Now()
+
+							if ctx.IsSet("since") {
+								if since, err = utils.ParseTimeFilter(ctx.String("since"), now); err != nil {
 									return err
 								}
+							}
 
-								location := device.GetLocation()
-								country := location.GetCountry()
+							if ctx.IsSet("until") {
+								if until, err = utils.ParseTimeFilter(ctx.String("until"), now); err != nil {
+									return err
+								}
+							}
 
-								fmt.Printf("Connected to %s, %s\n", location.GetName(), country.GetName())
+							var entries []auth.HistoryEntry
+							if daemon.Available() {
+								entries, err = daemon.NewClient().History(since, until)
 							} else {
-								fmt.Println("Disconnected")
+								entries, err = auth.LoadHistory(auth.OpenUserDB().CurrentUser().ID, since, until)
 							}
 
-							return nil
+							if err != nil {
+								logger.WithError(err).Debugf("failed to %+v", err)
+								return err
+							}
 
+							if ctx.IsSet("name") {
+								var filtered []auth.HistoryEntry
+								for _, entry := range entries {
+									if entry.Tunnel == name {
+										filtered = append(filtered, entry)
+									}
+								}
+								entries = filtered
+							}
+
+							return printHistory(entries, ctx.Bool("json"))
+						},
+					},
+					{
+						Name:  "check-dns",
+						Usage: "check that no resolver answers the DNS queries outside of the tunnel",
+						Flags: []cli.Flag{nameFlag},
+						Action: func(ctx *cli.Context) error {
+							profile := auth.OpenUserDB().CurrentUser()
+							state, err := actions.GetState(profile.ID, name, backend)
+							if err != nil {
+								logger.WithError(err).Debugf("failed to %+v", err)
+								return err
+							}
+
+							if !state.GetStatus() {
+								fmt.Println("State is down")
+								os.Exit(1)
+							}
+
+							leaks, err := actions.CheckDNSLeaks(state.WiregaurdInterface)
+							if err != nil {
+								logger.WithError(err).Debugf("failed to %+v", err)
+								return err
+							}
+
+							if len(leaks) == 0 {
+								fmt.Println("No DNS leaks found")
+								return nil
+							}
+
+							for _, leak := range leaks {
+								fmt.Printf("DNS leak: %s answers through %s\n", leak.Server, leak.Interface)
+							}
+							os.Exit(1)
+							return nil
 						},
 					},
 				},
 			},
+			{
+				Name:      "exec",
+				Usage:     "run the command in a network namespace connected only through the device of the tunnel, or with it's traffic routed through the running tunnel or outside of it",
+				ArgsUsage: "-- COMMAND [ARGS...]",
+				Flags: []cli.Flag{
+					nameFlag,
+					&cli.BoolFlag{
+						Name:  "through",
+						Usage: "route the traffic of the command through the tunnel, even if the tunnel does not route everything",
+						Value: false,
+					},
+					&cli.BoolFlag{
+						Name:  "bypass",
+						Usage: "route the traffic of the command outside of the tunnels",
+						Value: false,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Bool("through") && c.Bool("bypass") {
+						return errors.New("--through and --bypass are mutually exclusive")
+					}
+
+					mode := actions.ExecIsolated
+					if c.Bool("through") {
+						mode = actions.ExecThrough
+					} else if c.Bool("bypass") {
+						mode = actions.ExecBypass
+					}
+
+					profile := auth.OpenUserDB().CurrentUser()
+					state, err := actions.GetState(profile.ID, name, backend)
+					if err != nil {
+						logger.WithError(err).Debugf("failed to %+v", err)
+						return err
+					}
+
+					err = actions.Exec(profile.ID, state, mode, c.Args().Slice())
+					var exitErr *exec.ExitError
+					if errors.As(err, &exitErr) {
+						os.Exit(exitErr.ExitCode())
+					} else if err != nil {
+						logger.WithError(err).Debugf("failed to %+v", err)
+						return err
+					}
+
+					return nil
+				},
+			},
 			{
 				Name:  "location",
 				Usage: "manage ForestVPN locations",
@@ -419,14 +851,27 @@ func main() {
 					{
 						Name:  "status",
 						Usage: "see the location is set as default location to connect",
+						Flags: []cli.Flag{nameFlag},
 						Action: func(cCtx *cli.Context) error {
+							if daemon.Available() {
+								location, err := daemon.NewClient().Location(name)
+								if err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
+								}
+
+								country := location.GetCountry()
+								fmt.Printf("Default location is set to %s, %s\n", location.GetName(), country.GetName())
+								return nil
+							}
+
 							profile := auth.OpenUserDB().CurrentUser()
 							if err = profile.SignIn(utils.ApiHost); err != nil {
 								logger.WithError(err).Debugf("failed to %+v", err)
 								return err
 							}
 
-							device, err := auth.LoadDevice(profile.ID)
+							device, err := auth.LoadTunnelDevice(profile.ID, name)
 
 							if err != nil {
 								logger.WithError(err).Debugf("failed to %+v", err)
@@ -441,110 +886,116 @@ func main() {
 					},
 					{
 						Name:  "set",
-						Usage: "set the default location by specifying `UUID` or `Name`",
+						Usage: "set the default location by specifying `UUID`, city, country or ISO code",
+						Flags: []cli.Flag{
+							nameFlag,
+							refreshFlag,
+							&cli.BoolFlag{
+								Name:  "fastest",
+								Usage: "probe the available locations and set the one with the lowest latency",
+							},
+							&cli.StringFlag{
+								Name:        "country",
+								Destination: &country,
+								Usage:       "choose the fastest location within specific country",
+								Aliases:     []string{"c"},
+							},
+						},
 						Action: func(cCtx *cli.Context) error {
-							profile := auth.OpenUserDB().CurrentUser()
-							if err = profile.SignIn(utils.ApiHost); err != nil {
-								logger.WithError(err).Debugf("failed to %+v", err)
-								return err
-							}
-
-							state := actions.State{WiregaurdInterface: "fvpn0"}
-
-							if state.GetStatus() {
-								fmt.Println("Please, set down the connection before setting a new location.")
-								fmt.Println("Try 'fvpn state down'")
-								return nil
-							}
-
 							arg := cCtx.Args().Get(0)
+							fastest := cCtx.Bool("fastest")
 
-							if len(arg) < 1 {
-								logger.WithError(err).Debugf("failed to %+v", err)
+							if len(arg) < 1 && !fastest {
 								return errors.New("UUID or name required")
 							}
 
-							authClientWrapper, err := actions.GetAuthClientWrapper(profile, utils.ApiHost)
-							if err != nil {
-								logger.WithError(err).Debugf("failed to %+v", err)
-								return err
+							if len(arg) > 0 && fastest {
+								return errors.New("either UUID or name or --fastest expected")
 							}
 
-							locations, err := authClientWrapper.ApiClient.GetLocations()
-							if err != nil {
-								logger.WithError(err).Debugf("failed to %+v", err)
-								return err
+							if len(country) > 0 && !fastest {
+								return errors.New("--country requires --fastest")
 							}
 
-							wrappedLocations := actions.GetLocationWrappers(locations)
-							var location actions.LocationWrapper
-							id, err := uuid.Parse(arg)
-							found := false
-
-							if err != nil {
-								for _, loc := range wrappedLocations {
-									if strings.EqualFold(loc.Location.GetName(), arg) {
-										location = loc
-										found = true
-										break
-									}
+							if daemon.Available() {
+								var location forestvpn_api.Location
+								if fastest {
+									location, err = daemon.NewClient().SetFastestLocation(name, country, cCtx.Bool("refresh"))
+								} else {
+									location, err = daemon.NewClient().SetLocation(name, arg, cCtx.Bool("refresh"))
 								}
-							} else {
-								for _, loc := range wrappedLocations {
-									if strings.EqualFold(location.Location.GetId(), id.String()) {
-										location = loc
-										found = false
-										break
-									}
+								if errors.Is(err, daemon.ErrConnected) {
+									fmt.Println("Please, set down the connection before setting a new location.")
+									fmt.Println("Try 'fvpn state down'")
+									return nil
 								}
-							}
 
-							if !found {
-								err := fmt.Errorf("no such location: %s", arg)
-								logger.WithError(err).Debugf("failed to %+v", err)
-								return err
-							}
-
-							b, err := authClientWrapper.GetUnexpiredOrMostRecentBillingFeature(profile.ID)
-
-							if err != nil {
-								logger.WithError(err).Debugf("failed to %+v", err)
-								return err
-							}
+								var subscriptionErr *actions.SubscriptionError
+								if errors.As(err, &subscriptionErr) {
+									fmt.Println(subscriptionErr)
+									return nil
+								}
 
-							bid := b.GetBundleId()
-							expired := time.Now().After(b.GetExpiryDate())
+								if err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
+								}
 
-							if location.Premium && bid == "com.forestvpn.freemium" || expired {
-								fmt.Printf("The location you want to use is now unavailable, as it requires a paid subscription. You can unlock it by going Premium at %s.\n", url)
+								country := location.GetCountry()
+								fmt.Printf("Default location is set to %s, %s\n", location.GetName(), country.GetName())
 								return nil
 							}
 
-							device, err := auth.LoadDevice(profile.ID)
+							profile := auth.OpenUserDB().CurrentUser()
+							authClientWrapper, err := actions.GetLazyAuthClientWrapper(profile, utils.ApiHost)
 							if err != nil {
 								logger.WithError(err).Debugf("failed to %+v", err)
 								return err
 							}
+							authClientWrapper.RefreshLocations = cCtx.Bool("refresh")
 
-							device, err = authClientWrapper.ApiClient.UpdateDevice(device.GetId(), location.Location.GetId())
-							if err != nil {
-								logger.WithError(err).Debugf("failed to %+v", err)
-								return err
-							}
+							if len(name) == 0 || auth.TunnelExists(profile.ID, name) {
+								state, err := actions.GetState(profile.ID, name, backend)
+								if err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
+								}
 
-							err = auth.UpdateProfileDevice(device, profile.ID)
-							if err != nil {
-								logger.WithError(err).Debugf("failed to %+v", err)
-								return err
+								if state.GetStatus() {
+									fmt.Println("Please, set down the connection before setting a new location.")
+									fmt.Println("Try 'fvpn state down'")
+									return nil
+								}
 							}
 
-							if !utils.IsOpenWRT() {
-								err = authClientWrapper.SetLocation(device, profile.ID)
+							if fastest {
+								latency, err := authClientWrapper.SetFastestLocation(profile.ID, name, country, actions.DefaultProbeTimeout)
+								var subscriptionErr *actions.SubscriptionError
+								if errors.As(err, &subscriptionErr) {
+									fmt.Println(subscriptionErr)
+									return nil
+								}
 
 								if err != nil {
 									logger.WithError(err).Debugf("failed to %+v", err)
 									return err
 								}
+
+								country := latency.Location.Location.GetCountry()
+								fmt.Printf("Default location is set to %s, %s (%s)\n", latency.Location.Location.GetName(), country.GetName(), latency.RTT.Round(time.Millisecond))
+								return nil
+							}
+
+							location, err := authClientWrapper.SetDefaultLocation(profile.ID, name, arg)
+							var subscriptionErr *actions.SubscriptionError
+							if errors.As(err, &subscriptionErr) {
+								fmt.Println(subscriptionErr)
+								return nil
+							}
+
+							if err != nil {
+								logger.WithError(err).Debugf("failed to %+v", err)
+								return err
 							}
 
 							country := location.Location.GetCountry()
@@ -556,6 +1007,7 @@ func main() {
 						Name:  "ls",
 						Usage: "show available ForestVPN locations",
 						Flags: []cli.Flag{
+							refreshFlag,
 							&cli.StringFlag{
 								Name:        "country",
 								Destination: &country,
@@ -564,22 +1016,146 @@ func main() {
 								Aliases:     []string{"c"},
 								Required:    false,
 							},
+							&cli.StringFlag{
+								Name:    "output",
+								Usage:   fmt.Sprintf("output format, one of %s", strings.Join(actions.OutputFormats, ", ")),
+								Value:   actions.OutputFormats[0],
+								Aliases: []string{"o"},
+							},
+							&cli.BoolFlag{
+								Name:  "premium",
+								Usage: "show only the locations requiring a paid subscription",
+							},
+							&cli.BoolFlag{
+								Name:  "free",
+								Usage: "show only the locations available on the free plan",
+							},
+							&cli.StringFlag{
+								Name:  "sort",
+								Usage: fmt.Sprintf("order of the locations, one of %s, sorting by latency probes the locations", strings.Join(actions.LocationSorts, ", ")),
+								Value: actions.LocationSorts[0],
+							},
 						},
 						Action: func(c *cli.Context) error {
+							options := actions.ListOptions{
+								Country: country,
+								Premium: c.Bool("premium"),
+								Free:    c.Bool("free"),
+								Sort:    c.String("sort"),
+							}
+
+							if err := options.Validate(); err != nil {
+								return err
+							}
+
+							if !slices.Contains(actions.OutputFormats, c.String("output")) {
+								return fmt.Errorf("unknown output format: %s, expected one of %s", c.String("output"), strings.Join(actions.OutputFormats, ", "))
+							}
+
+							if daemon.Available() {
+								locations, err := daemon.NewClient().Locations(options, c.Bool("refresh"))
+								if err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
+								}
+
+								return actions.RenderLocations(os.Stdout, locations, c.String("output"))
+							}
+
 							profile := auth.OpenUserDB().CurrentUser()
-							if err = profile.SignIn(utils.ApiHost); err != nil {
+							authClientWrapper, err := actions.GetLazyAuthClientWrapper(profile, utils.ApiHost)
+							if err != nil {
 								logger.WithError(err).Debugf("failed to %+v", err)
 								return err
 							}
+							authClientWrapper.RefreshLocations = c.Bool("refresh")
 
-							authClientWrapper, err := actions.GetAuthClientWrapper(profile, utils.ApiHost)
+							return authClientWrapper.ListLocations(profile.ID, options, c.String("output"))
+						},
+					},
+					{
+						Name:      "ping",
+						Usage:     "measure the latency to the location by `UUID` or `Name`, or to all the available locations",
+						ArgsUsage: "[UUID|Name]",
+						Flags: []cli.Flag{
+							refreshFlag,
+							&cli.StringFlag{
+								Name:        "country",
+								Destination: &country,
+								Usage:       "ping locations by specific country",
+								Aliases:     []string{"c"},
+							},
+							&cli.BoolFlag{
+								Name:  "watch",
+								Usage: "probe the locations again and refresh the table until interrupted",
+							},
+							&cli.DurationFlag{
+								Name:  "interval",
+								Usage: "time between the probes in the watch mode",
+								Value: 5 * time.Second,
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							arg := cCtx.Args().Get(0)
+							// The locations are only fetched again for the first probe, the watch mode probes the same locations.
+							refreshLocations := cCtx.Bool("refresh")
+							var ping func(refresh bool) ([]actions.LocationLatency, error)
+
+							if daemon.Available() {
+								client := daemon.NewClient()
+								ping = func(refresh bool) ([]actions.LocationLatency, error) {
+									return client.Ping(arg, country, refresh, refreshLocations)
+								}
+							} else {
+								profile := auth.OpenUserDB().CurrentUser()
+								authClientWrapper, err := actions.GetLazyAuthClientWrapper(profile, utils.ApiHost)
+								if err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
+								}
 
+								ping = func(refresh bool) ([]actions.LocationLatency, error) {
+									authClientWrapper.RefreshLocations = refreshLocations
+									return authClientWrapper.PingLocations(profile.ID, arg, country, actions.DefaultProbeTimeout, refresh)
+								}
+							}
+
+							latencies, err := ping(refreshLocations)
 							if err != nil {
 								logger.WithError(err).Debugf("failed to %+v", err)
 								return err
 							}
+							refreshLocations = false
+
+							if !cCtx.Bool("watch") {
+								actions.PrintLatencies(latencies)
+								return nil
+							}
 
-							return authClientWrapper.ListLocations(country)
+							ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+							defer stop()
+
+							ticker := time.NewTicker(cCtx.Duration("interval"))
+							defer ticker.Stop()
+
+							for {
+								// Clear the terminal, so the table is redrawn in place.
+								fmt.Print("\033[H\033[2J")
+								fmt.Printf("Probed at %s, every %s. Press Ctrl+C to stop.\n\n", time.Now().Format(time.TimeOnly), cCtx.Duration("interval"))
+								actions.PrintLatencies(latencies)
+
+								select {
+								case <-ctx.Done():
+									return nil
+								case <-ticker.C:
+								}
+
+								latencies, err = ping(true)
+								if err != nil {
+									logger.WithError(err).Debugf("failed to %+v", err)
+									return err
+								}
+							}
 						},
 					},
 				},
@@ -598,3 +1174,152 @@ func main() {
 
 	}
 }
+
+// printAccountStatus is a function that prints the email, the plan and the expiry date of the account.
+func printAccountStatus(email string, b forestvpn_api.BillingFeature) {
+	expiryDate := b.GetExpiryDate()
+	now := time.Now()
+	left := expiryDate.Sub(now)
+	caser := cases.Title(language.English)
+	plan := caser.String(strings.Split(b.GetBundleId(), ".")[2])
+	fmt.Printf("Logged-in as %s\n", email)
+	fmt.Printf("Plan: %s\n", plan)
+	tz, err := utils.GetLocalTimezone()
+
+	if err != nil {
+		sentry.CaptureException(err)
+		_, offset := now.Zone()
+
+		tz = timezone.GetGmtTimezone(offset)
+	}
+
+	if now.After(expiryDate) {
+		t := now.Sub(expiryDate)
+		fmt.Printf("Status: expired %s ago at %s %s\n", utils.HumanizeDuration(t), expiryDate.Format("2006-01-02 15:04:05"), tz)
+	} else {
+		fmt.Printf("Status: expires in %s at %s %s\n", utils.HumanizeDuration(left), expiryDate.Format("2006-01-02 15:04:05"), tz)
+	}
+}
+
+// printConnectionStatus is a function that prints the location, the peer stats and the uptime of the connection, or the whole status as JSON.
+func printConnectionStatus(status actions.ConnectionStatus, asJSON bool) error {
+	if asJSON {
+		data, err := json.MarshalIndent(status, "", "    ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if !status.Connected {
+		fmt.Println("Disconnected")
+		return nil
+	}
+
+	country := status.Location.GetCountry()
+	fmt.Printf("Connected to %s, %s\n", status.Location.GetName(), country.GetName())
+	if status.Via != nil {
+		viaCountry := status.Via.GetCountry()
+		fmt.Printf("Via: %s, %s\n", status.Via.GetName(), viaCountry.GetName())
+	}
+	fmt.Printf("Interface: %s (%s)\n", status.Interface, status.Backend)
+
+	if status.ConnectedSince != nil {
+		fmt.Printf("Connected since: %s (%s)\n", status.ConnectedSince.Format("2006-01-02 15:04:05"), utils.HumanizeDuration(time.Since(*status.ConnectedSince)))
+	}
+
+	if stats := status.Stats; stats != nil {
+		fmt.Printf("Endpoint: %s\n", stats.Endpoint)
+
+		if stats.LatestHandshake.IsZero() {
+			fmt.Println("Latest handshake: none")
+		} else {
+			fmt.Printf("Latest handshake: %s ago\n", utils.HumanizeDuration(time.Since(stats.LatestHandshake)))
+		}
+
+		fmt.Printf("Transfer: %s received, %s sent\n", utils.HumanizeBytes(stats.RxBytes), utils.HumanizeBytes(stats.TxBytes))
+		fmt.Printf("Allowed IPs: %s\n", strings.Join(stats.AllowedIPs, ", "))
+	}
+
+	if status.MTU > 0 {
+		fmt.Printf("MTU: %d\n", status.MTU)
+	}
+
+	switch status.IPv6 {
+	case actions.IPv6Routed:
+		fmt.Println("IPv6: routed through the tunnel")
+	case actions.IPv6Forced:
+		fmt.Println("IPv6: routed through the tunnel, the location offers no IPv6 route")
+	case actions.IPv6Blocked:
+		fmt.Println("IPv6: blocked, the location offers no IPv6 route")
+	case actions.IPv6Leaking:
+		fmt.Println("IPv6: not protected, the location offers no IPv6 route")
+	}
+
+	return nil
+}
+
+// printHistory is a function that prints the entries of the connection journal as a table, or as JSON.
+func printHistory(entries []auth.HistoryEntry, asJSON bool) error {
+	if asJSON {
+		if entries == nil {
+			entries = []auth.HistoryEntry{}
+		}
+
+		data, err := json.MarshalIndent(entries, "", "    ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No connections recorded")
+		return nil
+	}
+
+	var data [][]string
+	for _, entry := range entries {
+		tunnel := entry.Tunnel
+		if len(tunnel) == 0 {
+			tunnel = "default"
+		}
+
+		location := entry.Location
+		if len(entry.Country) > 0 {
+			location = fmt.Sprintf("%s, %s", entry.Location, entry.Country)
+		}
+
+		var duration, received, sent string
+		if entry.Event == auth.HistoryDown {
+			if entry.Duration > 0 {
+				duration = utils.HumanizeDuration(time.Duration(entry.Duration) * time.Second)
+			}
+			received, sent = utils.HumanizeBytes(entry.RxBytes), utils.HumanizeBytes(entry.TxBytes)
+		}
+
+		data = append(data, []string{entry.Time.Local().Format("2006-01-02 15:04:05"), entry.Event, tunnel, location, entry.Backend, duration, received, sent, entry.Reason})
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Time", "Event", "Tunnel", "Location", "Backend", "Duration", "Received", "Sent", "Reason"})
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	table.AppendBulk(data)
+	table.Render()
+	return nil
+}
+
+// stringSliceFlag returns the values of the flag, or nil if the flag is not set, so an empty list given on the command line is told apart.
+func stringSliceFlag(c *cli.Context, name string) *[]string {
+	if !c.IsSet(name) {
+		return nil
+	}
+
+	values := c.StringSlice(name)
+	return &values
+}
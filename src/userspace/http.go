@@ -0,0 +1,125 @@
+package userspace
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ServeHTTPProxy accepts HTTP proxy clients on the listener and connects them through the tunnel until the listener is closed.
+// CONNECT requests are tunneled as is, plain HTTP requests with an absolute URI are forwarded.
+func (t *Tunnel) ServeHTTPProxy(listener net.Listener) error {
+	transport := &http.Transport{DialContext: t.DialContext}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodConnect {
+				t.handleConnect(w, r)
+				return
+			}
+
+			t.handleForward(transport, w, r)
+		}),
+	}
+
+	return server.Serve(listener)
+}
+
+func (t *Tunnel) handleConnect(w http.ResponseWriter, r *http.Request) {
+	upstream, err := t.DialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		return
+	}
+
+	pipe(&bufferedConn{Conn: client, reader: buffered.Reader}, upstream)
+}
+
+func (t *Tunnel) handleForward(transport *http.Transport, w http.ResponseWriter, r *http.Request) {
+	if !r.URL.IsAbs() {
+		http.Error(w, "this is a proxy server, absolute URI is required", http.StatusBadRequest)
+		return
+	}
+
+	request := r.Clone(r.Context())
+	request.RequestURI = ""
+	request.Header.Del("Proxy-Connection")
+	request.Header.Del("Proxy-Authorization")
+
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer response.Body.Close()
+
+	for key, values := range response.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(response.StatusCode)
+	_, _ = io.Copy(w, response.Body)
+}
+
+// bufferedConn is a net.Conn reading the bytes already buffered during the handshake first.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// pipe copies the data between both connections until either of them is closed.
+func pipe(client net.Conn, upstream net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(upstream, client)
+		closeWrite(upstream)
+	}()
+
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(client, upstream)
+		closeWrite(client)
+	}()
+
+	wg.Wait()
+}
+
+// closeWrite half-closes the connection if it supports that, so the other side sees EOF.
+func closeWrite(conn net.Conn) {
+	if bc, ok := conn.(*bufferedConn); ok {
+		conn = bc.Conn
+	}
+
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	} else {
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,141 @@
+// userspace is a package running the Wireguard tunnel inside of the process with wireguard-go and a gVisor network stack.
+// It requires neither root privileges nor the kernel Wireguard module, the tunnel is reachable through local SOCKS5 and HTTP proxies.
+//
+// See https://git.zx2c4.com/wireguard-go for more information.
+package userspace
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	forestvpn_api "github.com/forestvpn/api-client-go"
+	"github.com/forestvpn/cli/utils"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DefaultMTU is the MTU of the userspace tunnel, the same wg-quick uses for the most of the links.
+const DefaultMTU = 1420
+
+// Tunnel is a structure holding the wireguard-go device and the network stack the proxies dial through.
+type Tunnel struct {
+	device *device.Device
+	net    *netstack.Net
+}
+
+// NewTunnel is a factory function that starts the userspace tunnel with the addresses, DNS servers and peers of the ForestVPN device.
+func NewTunnel(d *forestvpn_api.Device, mtu int) (*Tunnel, error) {
+	var addresses []netip.Addr
+	for _, ip := range d.GetIps() {
+		prefix, err := netip.ParsePrefix(utils.HostPrefix(ip))
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, prefix.Addr())
+	}
+
+	var dns []netip.Addr
+	for _, ip := range d.GetDns() {
+		addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+		if err != nil {
+			return nil, err
+		}
+		dns = append(dns, addr)
+	}
+
+	tun, tnet, err := netstack.CreateNetTUN(addresses, dns, mtu)
+	if err != nil {
+		return nil, err
+	}
+
+	level := device.LogLevelError
+	if utils.Verbose {
+		level = device.LogLevelVerbose
+	}
+
+	wg := device.NewDevice(tun, conn.NewDefaultBind(), device.NewLogger(level, "[userspace] "))
+
+	config, err := ipcConfig(d)
+	if err != nil {
+		wg.Close()
+		return nil, err
+	}
+
+	if err := wg.IpcSet(config); err != nil {
+		wg.Close()
+		return nil, err
+	}
+
+	if err := wg.Up(); err != nil {
+		wg.Close()
+		return nil, err
+	}
+
+	return &Tunnel{device: wg, net: tnet}, nil
+}
+
+// DialContext connects to the address through the tunnel. Host names are resolved with the DNS servers of the device.
+func (t *Tunnel) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return t.net.DialContext(ctx, network, address)
+}
+
+// Close is a method to shut the tunnel down.
+func (t *Tunnel) Close() {
+	t.device.Close()
+}
+
+// ipcConfig renders the device keys and peers in the wireguard-go configuration protocol.
+//
+// See https://www.wireguard.com/xplatform/#configuration-protocol for more information.
+func ipcConfig(d *forestvpn_api.Device) (string, error) {
+	var config strings.Builder
+
+	privateKey, err := hexKey(d.Wireguard.GetPrivKey())
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(&config, "private_key=%s\nreplace_peers=true\n", privateKey)
+
+	for _, peer := range d.Wireguard.GetPeers() {
+		publicKey, err := hexKey(peer.GetPubKey())
+		if err != nil {
+			return "", err
+		}
+
+		endpoint, err := net.ResolveUDPAddr("udp", peer.GetEndpoint())
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(&config, "public_key=%s\nendpoint=%s\npersistent_keepalive_interval=25\n", publicKey, endpoint.String())
+
+		if len(peer.GetPsKey()) > 0 {
+			presharedKey, err := hexKey(peer.GetPsKey())
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&config, "preshared_key=%s\n", presharedKey)
+		}
+
+		for _, allowedIP := range peer.GetAllowedIps() {
+			fmt.Fprintf(&config, "allowed_ip=%s\n", strings.TrimSpace(allowedIP))
+		}
+	}
+
+	return config.String(), nil
+}
+
+// hexKey converts a base64 encoded Wireguard key into the hex encoding used by the configuration protocol.
+func hexKey(key string) (string, error) {
+	parsed, err := wgtypes.ParseKey(key)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(parsed[:]), nil
+}
@@ -0,0 +1,153 @@
+package userspace
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// SOCKS5 protocol constants.
+//
+// See https://www.rfc-editor.org/rfc/rfc1928 for more information.
+const (
+	socksVersion          = 0x05
+	socksNoAuth           = 0x00
+	socksNoAcceptable     = 0xff
+	socksConnect          = 0x01
+	socksIPv4             = 0x01
+	socksDomain           = 0x03
+	socksIPv6             = 0x04
+	socksSucceeded        = 0x00
+	socksGeneralFailure   = 0x01
+	socksCmdNotSupported  = 0x07
+	socksAddrNotSupported = 0x08
+)
+
+var errSocksVersion = errors.New("unsupported SOCKS version")
+
+// ServeSOCKS5 accepts SOCKS5 clients on the listener and connects them through the tunnel until the listener is closed.
+// Only the CONNECT command without authentication is supported.
+func (t *Tunnel) ServeSOCKS5(listener net.Listener) error {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go t.handleSOCKS5(client)
+	}
+}
+
+func (t *Tunnel) handleSOCKS5(client net.Conn) {
+	defer client.Close()
+
+	reader := bufio.NewReader(client)
+	address, err := socksHandshake(reader, client)
+	if err != nil {
+		return
+	}
+
+	upstream, err := t.DialContext(context.Background(), "tcp", address)
+	if err != nil {
+		_ = socksReply(client, socksGeneralFailure)
+		return
+	}
+	defer upstream.Close()
+
+	if err := socksReply(client, socksSucceeded); err != nil {
+		return
+	}
+
+	pipe(&bufferedConn{Conn: client, reader: reader}, upstream)
+}
+
+// socksHandshake negotiates the authentication method and reads the CONNECT request, returning the requested address.
+func socksHandshake(reader *bufio.Reader, writer io.Writer) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", err
+	}
+
+	if header[0] != socksVersion {
+		return "", errSocksVersion
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return "", err
+	}
+
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+
+	if _, err := writer.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+
+	if method == socksNoAcceptable {
+		return "", errors.New("no acceptable SOCKS authentication methods")
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(reader, request); err != nil {
+		return "", err
+	}
+
+	if request[0] != socksVersion {
+		return "", errSocksVersion
+	}
+
+	if request[1] != socksConnect {
+		_ = socksReply(writer, socksCmdNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS command: %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksIPv4, socksIPv6:
+		size := net.IPv4len
+		if request[3] == socksIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socksDomain:
+		length, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		domain := make([]byte, length)
+		if _, err := io.ReadFull(reader, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		_ = socksReply(writer, socksAddrNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS address type: %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply writes a reply with the given status and an unspecified bound address.
+func socksReply(writer io.Writer, status byte) error {
+	_, err := writer.Write([]byte{socksVersion, status, 0x00, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
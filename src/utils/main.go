@@ -31,6 +31,19 @@ func ip2Net(ip string) string {
 	return strings.Join(strings.Split(ip, ".")[:3], ".") + ".0/24"
 }
 
+// HostPrefix is a function that appends a host prefix length to a bare IP address, e.g. 10.0.0.2 becomes 10.0.0.2/32 and fd00::2 becomes fd00::2/128.
+// Networks that already have a prefix length are returned as is.
+func HostPrefix(ip string) string {
+	ip = strings.TrimSpace(ip)
+	if strings.Contains(ip, "/") {
+		return ip
+	}
+	if strings.Contains(ip, ":") {
+		return ip + "/128"
+	}
+	return ip + "/32"
+}
+
 // ExcludeDisallowedIps is a function that expects two slices of a network values, e.g. [127.0.0.0/8,], where disallowed is a slice of networks to be excluded from the allowed slice.
 // Returns a new slice of networks formed out of the allowed slice without networks of disallowed slice.
 func ExcludeDisallowedIps(allowed []string, disallowed string) ([]string, error) {
@@ -147,6 +160,49 @@ func HumanizeDuration(duration time.Duration) string {
 		int64(remainingMinutes), int64(remainingSeconds))
 }
 
+// HumanizeBytes is a function that formats the number of bytes with binary prefixes, e.g. 1.5 MiB.
+func HumanizeBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// timeLayouts are the absolute time formats accepted by ParseTimeFilter, in the local time zone unless the offset is given.
+var timeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// ParseTimeFilter is a function that parses an absolute time, e.g. 2024-05-01 or 2024-05-01 13:30, or a time relative to now,
+// e.g. 90m, 24h or 7d ago, as given to the date filters.
+func ParseTimeFilter(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+
+	relative := strings.TrimSpace(strings.TrimSuffix(value, "ago"))
+	if days, found := strings.CutSuffix(relative, "d"); found {
+		var n int
+		if _, err := fmt.Sscanf(days, "%d", &n); err == nil && fmt.Sprint(n) == days && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	} else if duration, err := time.ParseDuration(relative); err == nil && duration >= 0 {
+		return now.Add(-duration), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time: %s, expected a date, e.g. 2024-05-01 or 2024-05-01 13:30, or a duration, e.g. 24h or 7d", value)
+}
+
 func GetLocalTimezone() (string, error) {
 	b, err := ioutil.ReadFile("/etc/timezone")
 
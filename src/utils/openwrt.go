@@ -64,7 +64,8 @@ func Network(
 	wiregaurdPreSharedKey string,
 	wiregaurdEndpointHost string,
 	wiregaurdEndpointPort string,
-	wireguardAllowedIps []string) error {
+	wireguardAllowedIps []string,
+	wireguardMTU int) error {
 	err := exec.Command("uci", "delete", fmt.Sprintf("network.%s", wiregaurdInterface)).Run()
 	if err != nil {
 		sentry.CaptureException(err)
@@ -93,6 +94,13 @@ func Network(
 		return err
 	}
 
+	if wireguardMTU > 0 {
+		err = exec.Command("uci", "set", fmt.Sprintf("network.%s.mtu=%d", wiregaurdInterface, wireguardMTU)).Run()
+		if err != nil {
+			return err
+		}
+	}
+
 	err = exec.Command("uci", "delete", "network.wgserver").Run()
 	if err != nil {
 		sentry.CaptureException(err)
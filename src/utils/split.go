@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// addrRange is an inclusive range of IP addresses of the same family.
+type addrRange struct {
+	first netip.Addr
+	last  netip.Addr
+}
+
+// AllowedIPs is a function that computes the minimal set of networks covering the allowed and the routed networks except the excluded ones.
+// IPv4 and IPv6 networks are accepted in any of the slices, bare addresses are treated as host networks.
+// The result is sorted with IPv4 networks first.
+func AllowedIPs(allowed []string, routes []string, excludes []string) ([]string, error) {
+	include, err := parseRanges(append(append([]string{}, allowed...), routes...))
+	if err != nil {
+		return nil, err
+	}
+
+	exclude, err := parseRanges(excludes)
+	if err != nil {
+		return nil, err
+	}
+
+	var networks []string
+	for _, r := range subtractRanges(mergeRanges(include), mergeRanges(exclude)) {
+		for _, prefix := range rangePrefixes(r) {
+			networks = append(networks, prefix.String())
+		}
+	}
+
+	return networks, nil
+}
+
+func parseRanges(networks []string) ([]addrRange, error) {
+	var ranges []addrRange
+	for _, network := range networks {
+		network = strings.TrimSpace(network)
+		if len(network) == 0 {
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(HostPrefix(network))
+		if err != nil {
+			return nil, fmt.Errorf("invalid network: %s", network)
+		}
+
+		prefix = prefix.Masked()
+		ranges = append(ranges, addrRange{first: prefix.Addr(), last: lastAddr(prefix)})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].first.Less(ranges[j].first)
+	})
+
+	return ranges, nil
+}
+
+// mergeRanges joins the overlapping and adjacent ranges of the sorted slice.
+func mergeRanges(ranges []addrRange) []addrRange {
+	var merged []addrRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 {
+			previous := &merged[n-1]
+			next := previous.last.Next()
+			if previous.last.BitLen() == r.first.BitLen() && (!next.IsValid() || !next.Less(r.first)) {
+				if previous.last.Less(r.last) {
+					previous.last = r.last
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// subtractRanges removes the excluded ranges from the included ones, both being merged and sorted.
+func subtractRanges(include []addrRange, exclude []addrRange) []addrRange {
+	var result []addrRange
+	for _, r := range include {
+		remaining := []addrRange{r}
+		for _, e := range exclude {
+			var next []addrRange
+			for _, c := range remaining {
+				if c.first.BitLen() != e.first.BitLen() || e.last.Less(c.first) || c.last.Less(e.first) {
+					next = append(next, c)
+					continue
+				}
+				if c.first.Less(e.first) {
+					next = append(next, addrRange{first: c.first, last: e.first.Prev()})
+				}
+				if e.last.Less(c.last) {
+					next = append(next, addrRange{first: e.last.Next(), last: c.last})
+				}
+			}
+			remaining = next
+		}
+		result = append(result, remaining...)
+	}
+	return result
+}
+
+// rangePrefixes splits the range into the fewest networks covering it exactly.
+func rangePrefixes(r addrRange) []netip.Prefix {
+	var prefixes []netip.Prefix
+	first := r.first
+	for {
+		bits := first.BitLen()
+		prefix := netip.PrefixFrom(first, bits)
+		for length := 0; length <= bits; length++ {
+			candidate := netip.PrefixFrom(first, length)
+			if candidate.Masked().Addr() == first && !r.last.Less(lastAddr(candidate)) {
+				prefix = candidate
+				break
+			}
+		}
+
+		prefixes = append(prefixes, prefix)
+		last := lastAddr(prefix)
+		if last == r.last {
+			return prefixes
+		}
+		first = last.Next()
+	}
+}
+
+// lastAddr returns the last address of the network.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Masked().Addr()
+	bytes := addr.AsSlice()
+	for i := prefix.Bits(); i < len(bytes)*8; i++ {
+		bytes[i/8] |= 0x80 >> (i % 8)
+	}
+
+	last, _ := netip.AddrFromSlice(bytes)
+	return last
+}
@@ -58,3 +58,139 @@ func TestHumanizeDuration(t *testing.T) {
 		t.Errorf("expected %q, got %q", expected, actual)
 	}
 }
+
+func TestHostPrefix(t *testing.T) {
+	cases := map[string]string{
+		"10.0.0.2":     "10.0.0.2/32",
+		"fd00::2":      "fd00::2/128",
+		"10.0.0.0/24":  "10.0.0.0/24",
+		" 172.16.0.1 ": "172.16.0.1/32",
+	}
+
+	for ip, expected := range cases {
+		actual := utils.HostPrefix(ip)
+		if expected != actual {
+			t.Errorf("expected %q, got %q", expected, actual)
+		}
+	}
+}
+
+func TestHumanizeBytes(t *testing.T) {
+	cases := map[int64]string{
+		512:                    "512 B",
+		1536:                   "1.5 KiB",
+		5 * 1024 * 1024:        "5.0 MiB",
+		3 * 1024 * 1024 * 1024: "3.0 GiB",
+	}
+
+	for bytes, expected := range cases {
+		actual := utils.HumanizeBytes(bytes)
+		if expected != actual {
+			t.Errorf("expected %q, got %q", expected, actual)
+		}
+	}
+}
+
+func TestAllowedIPs(t *testing.T) {
+	cases := []struct {
+		allowed  []string
+		routes   []string
+		excludes []string
+		expected []string
+	}{
+		{
+			allowed:  []string{"0.0.0.0/0", "::/0"},
+			excludes: []string{"128.0.0.0/1", "8000::/1"},
+			expected: []string{"0.0.0.0/1", "::/1"},
+		},
+		{
+			allowed:  []string{"10.0.0.0/24"},
+			routes:   []string{"10.0.1.0/24", "10.0.0.128/25"},
+			expected: []string{"10.0.0.0/23"},
+		},
+		{
+			allowed:  []string{"192.168.0.0/16"},
+			excludes: []string{"192.168.1.0/24"},
+			expected: strings.Split("192.168.0.0/24, 192.168.2.0/23, 192.168.4.0/22, 192.168.8.0/21, 192.168.16.0/20, 192.168.32.0/19, 192.168.64.0/18, 192.168.128.0/17", ", "),
+		},
+		{
+			allowed:  []string{"fd00::/64"},
+			routes:   []string{"10.0.0.1"},
+			excludes: []string{"fd00::/65"},
+			expected: []string{"10.0.0.1/32", "fd00::8000:0:0:0/65"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := utils.AllowedIPs(c.allowed, c.routes, c.excludes)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("expected %s, got %s", c.expected, actual)
+		}
+	}
+}
+
+func TestParseTimeFilter(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	cases := map[string]time.Time{
+		"2024-05-01":           time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		"2024-05-01 13:30":     time.Date(2024, 5, 1, 13, 30, 0, 0, time.UTC),
+		"2024-05-01T13:30:00Z": time.Date(2024, 5, 1, 13, 30, 0, 0, time.UTC),
+		"90m":                  time.Date(2024, 5, 10, 10, 30, 0, 0, time.UTC),
+		"7d":                   time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC),
+		"24h ago":              time.Date(2024, 5, 9, 12, 0, 0, 0, time.UTC),
+	}
+
+	for value, expected := range cases {
+		actual, err := utils.ParseTimeFilter(value, now)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if !expected.Equal(actual) {
+			t.Errorf("%s: expected %s, got %s", value, expected, actual)
+		}
+	}
+
+	for _, value := range []string{"", "yesterday", "-1d", "1.5d"} {
+		if _, err := utils.ParseTimeFilter(value, now); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestFoldName(t *testing.T) {
+	cases := map[string]string{
+		"São Paulo":      "sao paulo",
+		" sao-paulo ":    "sao paulo",
+		"Zürich":         "zurich",
+		"NEW  YORK CITY": "new york city",
+	}
+
+	for name, expected := range cases {
+		if actual := utils.FoldName(name); actual != expected {
+			t.Errorf("%q: expected %q, got %q", name, expected, actual)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"amsterdam", "amsterdam", 0},
+		{"amsterdm", "amsterdam", 1},
+		{"frankfrut", "frankfurt", 2},
+		{"", "oslo", 4},
+	}
+
+	for _, c := range cases {
+		if actual := utils.Levenshtein(c.a, c.b); actual != c.expected {
+			t.Errorf("%q, %q: expected %d, got %d", c.a, c.b, c.expected, actual)
+		}
+	}
+}